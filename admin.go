@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// minSessionIDPrefix is the shortest session ID prefix admin disconnect
+// accepts, so a typo cannot match a lot of connections.
+const minSessionIDPrefix = 8
+
+type adminCommandOptions struct {
+	outputOptions
+	SSH bool `long:"ssh" description:"Use the SSH ban list instead of the Minecraft one"`
+}
+
+type adminBindingOutput struct {
+	BindingStatus
+	Owner     string `json:"owner"`
+	SessionID string `json:"session_id"`
+}
+
+type adminBanOutput struct {
+	List   string    `json:"list"`
+	Target string    `json:"target"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+var adminCommands = []commandOutput{
+	{"bindings", "List every binding with its owner"},
+	{"connections", "List every SSH connection"},
+	{"disconnect", "Close the SSH connections of a user or session: disconnect <user|session-id>"},
+	{"unbind", "Remove bindings of any user: unbind <domain...>"},
+	{"bans", "List the global bans"},
+	{"ban", "Ban an IP address or CIDR range globally: ban <ip|cidr> [duration] [reason]"},
+	{"unban", "Lift a global ban: unban <ip|cidr>"},
+	{"reload-ca", "Reload the user CA keys and the revocation list, user configs apply at the next login"},
+}
+
+func isAdmin(conn *ssh.ServerConn) bool {
+	return conn.Permissions != nil && conn.Permissions.Extensions[extensionAdmin] == "true"
+}
+
+func (s *session) handleAdminCommand(args []string) error {
+	if !isAdmin(s.conn) {
+		return fmt.Errorf("permission denied")
+	}
+	var options adminCommandOptions
+	rest, err := s.parseArgs(args, &options, "Manage the whole router, run admin help for the subcommands")
+	if err != nil {
+		return err
+	}
+	rest = rest[1:]
+	if len(rest) == 0 {
+		return fmt.Errorf("no admin command specified, run admin help")
+	}
+	list, listName := banList, "minecraft"
+	if options.SSH {
+		list, listName = sshBanList, "ssh"
+	}
+	switch rest[0] {
+	case "bindings":
+		return s.handleAdminBindings()
+	case "connections", "conns":
+		return s.handleAdminConnections()
+	case "disconnect":
+		return s.handleAdminDisconnect(rest[1:])
+	case "unbind":
+		return s.handleAdminUnbind(rest[1:])
+	case "bans":
+		return s.handleAdminBans(list, listName)
+	case "ban":
+		return s.handleAdminBan(list, listName, rest[1:])
+	case "unban":
+		return s.handleAdminUnban(list, listName, rest[1:])
+	case "reload-ca":
+		return s.handleAdminReloadCA()
+	case "help":
+		if s.json {
+			return writeJSON(s.io, helpOutput{Commands: adminCommands})
+		}
+		_, _ = fmt.Fprintln(s.io, "Admin commands:")
+		for _, command := range adminCommands {
+			_, _ = fmt.Fprintf(s.io, "  %s - %s\n", command.Name, command.Description)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown admin command: %s", rest[0])
+	}
+}
+
+// allConnections returns every SSH connection of every user, oldest first.
+func allConnections() []*ssh.ServerConn {
+	var conns []*ssh.ServerConn
+	_ = bindings.EachConnection(func(conn *ssh.ServerConn) error {
+		conns = append(conns, conn)
+		return nil
+	})
+	sort.Slice(conns, func(i, j int) bool {
+		first, _ := connectionInfos.Get(conns[i])
+		second, _ := connectionInfos.Get(conns[j])
+		return first.ConnectedAt.Before(second.ConnectedAt)
+	})
+	return conns
+}
+
+func (s *session) handleAdminBindings() error {
+	var output struct {
+		Bindings []adminBindingOutput `json:"bindings"`
+	}
+	output.Bindings = []adminBindingOutput{}
+	for _, conn := range allConnections() {
+		info, _ := connectionInfos.Get(conn)
+		_ = bindings.EachBinding(conn, func(upstream McUpstream) error {
+			output.Bindings = append(output.Bindings, adminBindingOutput{
+				BindingStatus: NewBindingStatus(upstream),
+				Owner:         conn.User(),
+				SessionID:     info.SessionID,
+			})
+			return nil
+		})
+	}
+	sort.Slice(output.Bindings, func(i, j int) bool {
+		return output.Bindings[i].Domain < output.Bindings[j].Domain
+	})
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	_, _ = writer.Write([]byte("DOMAIN\tOWNER\tSESSION\tCONNECTIONS\tIN\tOUT\tRTT\n"))
+	for _, binding := range output.Bindings {
+		rtt := "-"
+		if binding.RTTMillis != nil {
+			rtt = fmt.Sprintf("%.1fms", *binding.RTTMillis)
+		}
+		_, _ = fmt.Fprintf(
+			writer, "%s\t%s\t%.16s\t%d\t%s\t%s\t%s\n",
+			binding.Domain, binding.Owner, binding.SessionID, binding.Connections,
+			formatBytes(binding.Traffic.BytesIn), formatBytes(binding.Traffic.BytesOut), rtt,
+		)
+	}
+	return writer.Flush()
+}
+
+func (s *session) handleAdminConnections() error {
+	var output struct {
+		Connections []infoOutput `json:"connections"`
+	}
+	output.Connections = []infoOutput{}
+	for _, conn := range allConnections() {
+		info, _ := connectionInfos.Get(conn)
+		output.Connections = append(output.Connections, infoOutput{ConnectionInfo: info, RTTMillis: roundTripMillis(conn)})
+	}
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	_, _ = writer.Write([]byte("SESSION\tUSER\tREMOTE\tSINCE\tAUTH\tRTT\n"))
+	for _, conn := range output.Connections {
+		rtt := "-"
+		if conn.RTTMillis != nil {
+			rtt = fmt.Sprintf("%.1fms", *conn.RTTMillis)
+		}
+		_, _ = fmt.Fprintf(
+			writer, "%.16s\t%s\t%s\t%s\t%s\t%s\n",
+			conn.SessionID, conn.User, conn.RemoteAddr, conn.ConnectedAt.Format(time.DateTime), conn.AuthMethod, rtt,
+		)
+	}
+	return writer.Flush()
+}
+
+// handleAdminDisconnect closes every SSH connection of a user, or the one
+// whose session ID starts with the given prefix.
+func (s *session) handleAdminDisconnect(targets []string) error {
+	if len(targets) != 1 {
+		return fmt.Errorf("a user or session ID must be specified")
+	}
+	target := targets[0]
+	var matched, sessions []*ssh.ServerConn
+	for _, conn := range allConnections() {
+		info, _ := connectionInfos.Get(conn)
+		if info.User == target {
+			matched = append(matched, conn)
+		} else if len(target) >= minSessionIDPrefix && strings.HasPrefix(info.SessionID, target) {
+			sessions = append(sessions, conn)
+		}
+	}
+	if len(matched) == 0 {
+		if len(target) < minSessionIDPrefix {
+			return fmt.Errorf("no connection of user %s, session IDs need at least %d characters", target, minSessionIDPrefix)
+		}
+		if len(sessions) > 1 {
+			return fmt.Errorf("%s matches %d sessions, use a longer session ID", target, len(sessions))
+		}
+		matched = sessions
+	}
+	if len(matched) == 0 {
+		return fmt.Errorf("no connection matches %s", target)
+	}
+	for _, conn := range matched {
+		info, _ := connectionInfos.Get(conn)
+		log.Printf("[SSH] %s disconnected %v", s.conn.User(), info)
+		_ = conn.Close()
+	}
+	if s.json {
+		return writeJSON(s.io, struct {
+			Disconnected int `json:"disconnected"`
+		}{len(matched)})
+	}
+	_, _ = fmt.Fprintf(s.io, "Disconnected %d connections\n", len(matched))
+	return nil
+}
+
+func (s *session) handleAdminUnbind(domains []string) error {
+	if len(domains) == 0 {
+		return fmt.Errorf("no bindings specified")
+	}
+	var output struct {
+		Removed []string `json:"removed"`
+	}
+	for _, domain := range domains {
+		if !bindings.HasBinding(domain) {
+			return fmt.Errorf("binding does not exist: %s", domain)
+		}
+	}
+	for _, domain := range domains {
+		bindings.RemoveBinding(domain)
+		log.Printf("[SSH] %s removed binding %s", s.conn.User(), domain)
+		output.Removed = append(output.Removed, domain)
+	}
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	_, _ = fmt.Fprintf(s.io, "Removed %s\n", strings.Join(output.Removed, " "))
+	return nil
+}
+
+func (s *session) handleAdminBans(list BanList, listName string) error {
+	var output struct {
+		Bans []adminBanOutput `json:"bans"`
+	}
+	output.Bans = []adminBanOutput{}
+	now := time.Now()
+	_ = list.Each(func(target string, ban Ban) error {
+		if ban.Until.After(now) {
+			output.Bans = append(output.Bans, adminBanOutput{List: listName, Target: target, Until: ban.Until, Reason: ban.Reason})
+		}
+		return nil
+	})
+	sort.Slice(output.Bans, func(i, j int) bool {
+		return output.Bans[i].Target < output.Bans[j].Target
+	})
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	_, _ = writer.Write([]byte("TARGET\tUNTIL\tREASON\n"))
+	for _, ban := range output.Bans {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\n", ban.Target, ban.Until.Format(time.DateTime), ban.Reason)
+	}
+	return writer.Flush()
+}
+
+func (s *session) handleAdminBan(list BanList, listName string, rest []string) error {
+	if len(rest) == 0 {
+		return fmt.Errorf("an IP address or CIDR range must be specified")
+	}
+	target, err := normalizeBanTarget(rest[0])
+	if err != nil {
+		return err
+	}
+	duration := time.Duration(opts.BanDuration) * time.Hour
+	rest = rest[1:]
+	if len(rest) > 0 {
+		if parsed, ok := parseBanDuration(rest[0]); ok {
+			duration = parsed
+			rest = rest[1:]
+		}
+	}
+	reason := strings.Join(rest, " ")
+	if reason == "" {
+		reason = "banned by admin"
+	}
+	until := time.Now().Add(duration).Round(0)
+	_ = list.Add(target, until, reason)
+	log.Printf("[SSH] %s banned %s on the %s ban list until %v (%s)", s.conn.User(), target, listName, until, reason)
+	if s.json {
+		return writeJSON(s.io, adminBanOutput{List: listName, Target: target, Until: until, Reason: reason})
+	}
+	_, _ = fmt.Fprintf(s.io, "Banned %s until %s\n", target, until.Format(time.DateTime))
+	return nil
+}
+
+func (s *session) handleAdminUnban(list BanList, listName string, rest []string) error {
+	if len(rest) != 1 {
+		return fmt.Errorf("an IP address or CIDR range must be specified")
+	}
+	if !list.Remove(rest[0]) {
+		return fmt.Errorf("%s is not banned", rest[0])
+	}
+	log.Printf("[SSH] %s unbanned %s from the %s ban list", s.conn.User(), rest[0], listName)
+	if s.json {
+		return writeJSON(s.io, struct {
+			List   string `json:"list"`
+			Target string `json:"target"`
+		}{listName, rest[0]})
+	}
+	_, _ = fmt.Fprintf(s.io, "Unbanned %s\n", rest[0])
+	return nil
+}
+
+// handleAdminReloadCA re-reads the user CA keys and the revocation list.
+// User configs are read on every login, so changes to them only reach a
+// connected user after admin disconnect.
+func (s *session) handleAdminReloadCA() error {
+	err := loadAuthorities()
+	if err != nil {
+		return fmt.Errorf("failed to reload: %v", err)
+	}
+	log.Printf("[SSH] %s reloaded the user CA keys and the revocation list", s.conn.User())
+	if s.json {
+		return writeJSON(s.io, struct {
+			Reloaded bool `json:"reloaded"`
+		}{true})
+	}
+	_, _ = fmt.Fprintln(s.io, "Reloaded")
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestAdminDisconnect(t *testing.T) {
+	bindings = NewBindingManager()
+	sessionIDs := map[string]string{"alice": "0123456789abcdef", "bob": "0123456789fedcba", "carol": "fedcba9876543210"}
+	conns := map[string]*ssh.ServerConn{}
+	for user, sessionID := range sessionIDs {
+		conn := newTestServerConn(user)
+		if err := bindings.AddConnection(conn); err != nil {
+			t.Fatal(err)
+		}
+		connectionInfos.Set(conn, ConnectionInfo{SessionID: sessionID, User: user})
+		defer connectionInfos.Remove(conn)
+		conns[user] = conn
+	}
+	channel := newTestChannel()
+	s := &session{conn: newTestServerConn("admin"), channel: channel, io: &sessionIO{channel, bufio.NewReader(channel)}}
+	closed := func(user string) bool {
+		return conns[user].Conn.(*testSSHConn).closed
+	}
+	for _, target := range []string{"", "0", "0123", "01234567"} {
+		if err := s.handleAdminDisconnect([]string{target}); err == nil {
+			t.Errorf("Expected %q to be refused [FAILED]", target)
+		}
+	}
+	if closed("alice") || closed("bob") || closed("carol") {
+		t.Errorf("Expected no connection to be closed by a short or ambiguous prefix [FAILED]")
+	}
+	if err := s.handleAdminDisconnect([]string{"0123456789a"}); err != nil || !closed("alice") || closed("bob") {
+		t.Errorf("Expected only the session of alice to be closed, got %v [FAILED]", err)
+	}
+	if err := s.handleAdminDisconnect([]string{"carol"}); err != nil || !closed("carol") || closed("bob") {
+		t.Errorf("Expected the connection of carol to be closed, got %v [FAILED]", err)
+	}
+}
+
+func TestAdminReloadCA(t *testing.T) {
+	channel := newTestChannel()
+	conn := newTestServerConn("admin")
+	conn.Permissions.Extensions[extensionAdmin] = "true"
+	s := &session{conn: conn, channel: channel, io: &sessionIO{channel, bufio.NewReader(channel)}}
+	if err := s.handleAdminCommand([]string{"admin", "reload-ca"}); err != nil {
+		t.Errorf("Expected the CA keys to be reloaded, got %v [FAILED]", err)
+	}
+	if err := s.handleAdminCommand([]string{"admin", "reload"}); err == nil {
+		t.Errorf("Expected admin reload to be unknown [FAILED]")
+	}
+}
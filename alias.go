@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"text/tabwriter"
+)
+
+type aliasOutput struct {
+	Binding string `json:"binding"`
+	Alias   string `json:"alias"`
+}
+
+func (s *session) handleAliasCommand(args []string) error {
+	var opts emptyCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Route extra hostnames to a binding: alias add <binding> <hostname>, alias rm <hostname...> or alias list [binding...]")
+	if err != nil {
+		return err
+	}
+	rest = rest[1:]
+	if len(rest) == 0 {
+		return s.handleAliasList(nil)
+	}
+	switch rest[0] {
+	case "add":
+		if len(rest) != 3 {
+			return fmt.Errorf("a binding and a hostname must be specified")
+		}
+		err = bindings.AddAlias(s.conn, rest[1], rest[2])
+		if err != nil {
+			return err
+		}
+		log.Printf("[SSH] %s added alias %s for %s", s.conn.User(), rest[2], rest[1])
+		if s.json {
+			return writeJSON(s.io, aliasOutput{Binding: rest[1], Alias: rest[2]})
+		}
+		_, _ = fmt.Fprintf(s.io, "Added alias %s for %s\n", rest[2], rest[1])
+	case "rm", "remove":
+		if len(rest) < 2 {
+			return fmt.Errorf("no aliases specified")
+		}
+		for _, alias := range rest[1:] {
+			err = bindings.RemoveAlias(s.conn, alias)
+			if err != nil {
+				return fmt.Errorf("%v: %s", err, alias)
+			}
+			log.Printf("[SSH] %s removed alias %s", s.conn.User(), alias)
+		}
+		if s.json {
+			return writeJSON(s.io, struct {
+				Removed []string `json:"removed"`
+			}{rest[1:]})
+		}
+		_, _ = fmt.Fprintf(s.io, "Removed %d aliases\n", len(rest)-1)
+	case "list", "ls":
+		return s.handleAliasList(rest[1:])
+	default:
+		return fmt.Errorf("unknown alias command: %s", rest[0])
+	}
+	return nil
+}
+
+func (s *session) handleAliasList(domains []string) error {
+	var patterns []string
+	if len(domains) == 0 {
+		_ = bindings.EachBinding(s.conn, func(upstream McUpstream) error {
+			patterns = append(patterns, upstream.Domain())
+			return nil
+		})
+		sort.Strings(patterns)
+	}
+	for _, domain := range domains {
+		if _, err := bindings.Lookup(s.conn, domain); err != nil {
+			return err
+		}
+		patterns = append(patterns, domain)
+	}
+	var output struct {
+		Aliases []aliasOutput `json:"aliases"`
+	}
+	output.Aliases = []aliasOutput{}
+	for _, pattern := range patterns {
+		for _, alias := range bindings.Aliases(pattern) {
+			output.Aliases = append(output.Aliases, aliasOutput{Binding: pattern, Alias: alias})
+		}
+	}
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	_, _ = writer.Write([]byte("BINDING\tALIAS\n"))
+	for _, alias := range output.Aliases {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\n", alias.Binding, alias.Alias)
+	}
+	return writer.Flush()
+}
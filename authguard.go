@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+type authFailures struct {
+	count uint32
+	since time.Time
+	until time.Time
+}
+
+type authGuard struct {
+	ips   map[string]*authFailures
+	users map[string]*authFailures
+	lock  sync.Mutex
+}
+
+// AuthGuard counts failed SSH logins per source IP and per username within
+// --auth-window. IPs over the limit are added to sshBanList, usernames over
+// the limit are locked without touching their config file.
+type AuthGuard interface {
+	Failed(ip net.IP, user string)
+	Succeeded(ip net.IP, user string)
+	UserLocked(user string) bool
+	Cleanup()
+}
+
+var authGuards = NewAuthGuard()
+
+func NewAuthGuard() AuthGuard {
+	return &authGuard{
+		ips:   make(map[string]*authFailures),
+		users: make(map[string]*authFailures),
+	}
+}
+
+func (g *authGuard) Failed(ip net.IP, user string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	now := time.Now()
+	banDuration := time.Duration(opts.AuthBanDuration) * time.Minute
+	if opts.AuthMaxIP > 0 && ip != nil {
+		if g.count(g.ips, ip.String(), now) >= opts.AuthMaxIP {
+			delete(g.ips, ip.String())
+			_ = sshBanList.Add(ip.String(), now.Add(banDuration), "too many failed logins")
+			log.Printf("[SSH] banned %s until %v after too many failed logins", ip, now.Add(banDuration))
+		}
+	}
+	if opts.AuthMaxUser > 0 && !g.locked(user, now) {
+		if g.count(g.users, user, now) >= opts.AuthMaxUser {
+			g.users[user].count = 0
+			g.users[user].until = now.Add(banDuration)
+			log.Printf("[SSH] locked user %s until %v after too many failed logins", user, now.Add(banDuration))
+		}
+	}
+}
+
+// Succeeded forgets the failures of an IP and a username once a login works,
+// so reconnecting users do not add up towards a ban or a lock.
+func (g *authGuard) Succeeded(ip net.IP, user string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if ip != nil {
+		delete(g.ips, ip.String())
+	}
+	if !g.locked(user, time.Now()) {
+		delete(g.users, user)
+	}
+}
+
+// count records a failure for key and returns the number of failures in the
+// current window.
+func (g *authGuard) count(failures map[string]*authFailures, key string, now time.Time) uint32 {
+	entry, ok := failures[key]
+	if !ok {
+		entry = &authFailures{since: now}
+		failures[key] = entry
+	}
+	if now.Sub(entry.since) > time.Duration(opts.AuthWindow)*time.Minute {
+		entry.count = 0
+		entry.since = now
+	}
+	entry.count++
+	return entry.count
+}
+
+func (g *authGuard) locked(user string, now time.Time) bool {
+	entry, ok := g.users[user]
+	return ok && entry.until.After(now)
+}
+
+func (g *authGuard) UserLocked(user string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.locked(user, time.Now())
+}
+
+func (g *authGuard) Cleanup() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	now := time.Now()
+	window := time.Duration(opts.AuthWindow) * time.Minute
+	for _, failures := range []map[string]*authFailures{g.ips, g.users} {
+		for key, entry := range failures {
+			if now.Sub(entry.since) > window && entry.until.Before(now) {
+				delete(failures, key)
+			}
+		}
+	}
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
+
+func checkAuthAllowed(conn ssh.ConnMetadata) error {
+	if authGuards.UserLocked(conn.User()) {
+		return fmt.Errorf("user is temporarily locked")
+	}
+	return nil
+}
+
+// handleAuthLog counts failed password and keyboard-interactive attempts.
+// Rejected public keys are not counted: clients offer every key they have,
+// the callback cannot tell those queries from signed attempts, and a key
+// cannot be guessed anyway. Neither is keyboard-interactive for users without
+// a TOTP secret, clients try it before the password on every login.
+func handleAuthLog(conn ssh.ConnMetadata, method string, err error) {
+	if err == nil {
+		authGuards.Succeeded(remoteIP(conn.RemoteAddr()), conn.User())
+		return
+	}
+	var partialSuccess *ssh.PartialSuccessError
+	if method == "none" || method == "publickey" || errors.As(err, &partialSuccess) || errors.Is(err, errNoSecondFactor) {
+		return
+	}
+	authGuards.Failed(remoteIP(conn.RemoteAddr()), conn.User())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestAuthLogCounting(t *testing.T) {
+	opts.AuthMaxIP = 3
+	opts.AuthWindow = 10
+	opts.AuthBanDuration = 30
+	authGuards = NewAuthGuard()
+	sshBanList = NewBanList()
+	ip := net.ParseIP("192.0.2.1")
+	conn := &testSSHConn{user: "alice", remoteAddr: &net.TCPAddr{IP: ip, Port: 2222}}
+	failed := fmt.Errorf("failed")
+	for i := 0; i < 10; i++ {
+		handleAuthLog(conn, "publickey", failed)
+	}
+	handleAuthLog(conn, "password", failed)
+	handleAuthLog(conn, "password", failed)
+	handleAuthLog(conn, "publickey", nil)
+	handleAuthLog(conn, "password", failed)
+	handleAuthLog(conn, "password", failed)
+	if _, banned := sshBanList.Check(ip); banned {
+		t.Errorf("Expected rejected keys and failures before a login not to ban the IP [FAILED]")
+	}
+	handleAuthLog(conn, "keyboard-interactive", failed)
+	if _, banned := sshBanList.Check(ip); !banned {
+		t.Errorf("Expected %d failed attempts to ban the IP [FAILED]", opts.AuthMaxIP)
+	}
+}
+
+func TestAuthLogSuccessResetsUser(t *testing.T) {
+	opts.AuthMaxIP = 0
+	opts.AuthMaxUser = 3
+	opts.AuthWindow = 10
+	opts.AuthBanDuration = 30
+	authGuards = NewAuthGuard()
+	defer func() {
+		authGuards = NewAuthGuard()
+	}()
+	failed := fmt.Errorf("failed")
+	for i := 0; i < 5; i++ {
+		conn := &testSSHConn{user: "alice", remoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 2222}}
+		handleAuthLog(conn, "password", failed)
+		handleAuthLog(conn, "password", failed)
+		handleAuthLog(conn, "password", nil)
+	}
+	if authGuards.UserLocked("alice") {
+		t.Errorf("Expected a successful login to reset the failures of the user [FAILED]")
+	}
+	conn := &testSSHConn{user: "alice", remoteAddr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 100), Port: 2222}}
+	for i := uint32(0); i < opts.AuthMaxUser; i++ {
+		handleAuthLog(conn, "password", failed)
+	}
+	if !authGuards.UserLocked("alice") {
+		t.Errorf("Expected %d failed attempts to lock the user [FAILED]", opts.AuthMaxUser)
+	}
+	handleAuthLog(conn, "password", nil)
+	if !authGuards.UserLocked("alice") {
+		t.Errorf("Expected a lock to outlast a login that got through before it [FAILED]")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Ban struct {
+	Until  time.Time
+	Reason string
+}
+
+type bans struct {
+	entries Map[string, Ban]
+}
+
+// BanList holds temporary bans of single IP addresses or CIDR ranges.
+type BanList interface {
+	Add(target string, until time.Time, reason string) error
+	Remove(target string) bool
+	Check(ip net.IP) (Ban, bool)
+	Each(callback func(target string, ban Ban) error) error
+	Cleanup()
+}
+
+// banList rejects Minecraft connections, sshBanList rejects SSH connections
+// before the handshake.
+var banList = NewBanList()
+var sshBanList = NewBanList()
+
+type bindingBanKey struct {
+	user   string
+	domain string
+}
+
+// bindingBans holds the bans tunnel owners put on their own bindings. They
+// are kept by owner and binding pattern, so they survive reconnects of the
+// tunnel but never apply to a binding of someone else.
+var bindingBans = NewMap[bindingBanKey, BanList]()
+var bindingBansLock sync.Mutex
+
+// BindingBans returns the ban list of a binding, creating it when create is
+// set.
+func BindingBans(upstream McUpstream, create bool) (BanList, bool) {
+	bindingBansLock.Lock()
+	defer bindingBansLock.Unlock()
+	key := bindingBanKey{user: upstream.SSHConn().User(), domain: upstream.Domain()}
+	list, ok := bindingBans.Get(key)
+	if !ok && create {
+		list, ok = NewBanList(), true
+		bindingBans.Set(key, list)
+	}
+	return list, ok
+}
+
+// CleanupBindingBans drops expired bans of bindings, and the ban lists left
+// empty once their owner no longer registers the binding.
+func CleanupBindingBans() {
+	bindingBansLock.Lock()
+	defer bindingBansLock.Unlock()
+	_ = bindingBans.Filter(func(key bindingBanKey, list BanList) (bool, error) {
+		list.Cleanup()
+		empty := true
+		_ = list.Each(func(_ string, _ Ban) error {
+			empty = false
+			return nil
+		})
+		if !empty {
+			return true, nil
+		}
+		registered := false
+		_ = bindings.EachUserBinding(key.user, func(upstream McUpstream) error {
+			registered = registered || upstream.Domain() == key.domain
+			return nil
+		})
+		return registered, nil
+	})
+}
+
+func NewBanList() BanList {
+	return &bans{
+		entries: NewMap[string, Ban](),
+	}
+}
+
+// normalizeBanTarget turns an IP address or CIDR range into the key it is
+// stored under.
+func normalizeBanTarget(target string) (string, error) {
+	if strings.Contains(target, "/") {
+		_, network, err := net.ParseCIDR(target)
+		if err != nil {
+			return "", err
+		}
+		return network.String(), nil
+	}
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address: %s", target)
+	}
+	return ip.String(), nil
+}
+
+// parseNetwork turns an IP address or CIDR range into a network, a single
+// address becomes a network of its own.
+func parseNetwork(target string) (*net.IPNet, error) {
+	if strings.Contains(target, "/") {
+		_, network, err := net.ParseCIDR(target)
+		return network, err
+	}
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", target)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (b *bans) Add(target string, until time.Time, reason string) error {
+	key, err := normalizeBanTarget(target)
+	if err != nil {
+		return err
+	}
+	b.entries.Set(key, Ban{Until: until, Reason: reason})
+	return nil
+}
+
+func (b *bans) Remove(target string) bool {
+	key, err := normalizeBanTarget(target)
+	if err != nil || !b.entries.Contains(key) {
+		return false
+	}
+	b.entries.Remove(key)
+	return true
+}
+
+func (b *bans) Check(ip net.IP) (Ban, bool) {
+	now := time.Now()
+	if ban, ok := b.entries.Get(ip.String()); ok && ban.Until.After(now) {
+		return ban, true
+	}
+	var found Ban
+	_ = b.entries.Each(func(target string, ban Ban) error {
+		if !strings.Contains(target, "/") || !ban.Until.After(now) {
+			return nil
+		}
+		_, network, err := net.ParseCIDR(target)
+		if err == nil && network.Contains(ip) && ban.Until.After(found.Until) {
+			found = ban
+		}
+		return nil
+	})
+	return found, !found.Until.IsZero()
+}
+
+func (b *bans) Each(callback func(target string, ban Ban) error) error {
+	return b.entries.Each(callback)
+}
+
+func (b *bans) Cleanup() {
+	now := time.Now()
+	_ = b.entries.Filter(func(target string, ban Ban) (bool, error) {
+		return ban.Until.After(now), nil
+	})
+}
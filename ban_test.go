@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCleanupBindingBans(t *testing.T) {
+	bindings = NewBindingManager()
+	bindingBans = NewMap[bindingBanKey, BanList]()
+	conn := newTestServerConn("alice", "*.example.com")
+	if err := bindings.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, binding := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		if err := bindings.AddBinding(conn, binding, 25565); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add := func(domain string, until time.Time) {
+		upstream, _ := bindings.Resolve(domain)
+		list, _ := BindingBans(upstream, true)
+		if err := list.Add("192.0.2.1", until, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("a.example.com", time.Now().Add(-time.Minute))
+	add("b.example.com", time.Now().Add(-time.Minute))
+	add("c.example.com", time.Now().Add(time.Hour))
+	bindings.RemoveBinding("b.example.com")
+	bindings.RemoveBinding("c.example.com")
+	CleanupBindingBans()
+	if !bindingBans.Contains(bindingBanKey{user: "alice", domain: "a.example.com"}) {
+		t.Errorf("Expected the ban list of a registered binding to be kept [FAILED]")
+	}
+	if bindingBans.Contains(bindingBanKey{user: "alice", domain: "b.example.com"}) {
+		t.Errorf("Expected the empty ban list of a removed binding to be dropped [FAILED]")
+	}
+	if !bindingBans.Contains(bindingBanKey{user: "alice", domain: "c.example.com"}) {
+		t.Errorf("Expected a ban list with active bans to survive the binding [FAILED]")
+	}
+}
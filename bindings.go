@@ -3,13 +3,21 @@ package main
 import (
 	"fmt"
 	"golang.org/x/crypto/ssh"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type bindingManager struct {
 	bindings        Matcher[McUpstream]
 	connections     Map[*ssh.ServerConn, Set[string]]
 	allowedBindings Map[*ssh.ServerConn, Matcher[bool]]
+	limits          Map[*ssh.ServerConn, UserLimits]
+	permitListen    Map[*ssh.ServerConn, []string]
+	aliases         Matcher[string]
+	bindingAliases  Map[string, Set[string]]
+	reservedPlayers Map[string, int]
 	lock            sync.RWMutex
 }
 
@@ -18,10 +26,19 @@ type BindingManager interface {
 	RemoveConnection(conn *ssh.ServerConn)
 	AddBinding(conn *ssh.ServerConn, pattern string, targetPort uint32) error
 	HasBinding(pattern string) bool
+	Lookup(conn *ssh.ServerConn, pattern string) (McUpstream, error)
 	Resolve(domain string) (McUpstream, bool)
+	ReservePlayer(upstream McUpstream) (func(), error)
 	RemoveBinding(pattern string)
 	SetProxyProtocol(conn *ssh.ServerConn, pattern string, proxyProtocol bool) error
+	SetIdleTimeout(conn *ssh.ServerConn, pattern string, timeout time.Duration) error
 	EachBinding(conn *ssh.ServerConn, callback func(upstream McUpstream) error) error
+	EachUserBinding(user string, callback func(upstream McUpstream) error) error
+	EachConnection(callback func(conn *ssh.ServerConn) error) error
+	UserAllows(user string, pattern string) (bool, bool)
+	AddAlias(conn *ssh.ServerConn, pattern string, alias string) error
+	RemoveAlias(conn *ssh.ServerConn, alias string) error
+	Aliases(pattern string) []string
 }
 
 func NewBindingManager() BindingManager {
@@ -29,6 +46,11 @@ func NewBindingManager() BindingManager {
 		bindings:        NewMatcher[McUpstream](),
 		connections:     NewMap[*ssh.ServerConn, Set[string]](),
 		allowedBindings: NewMap[*ssh.ServerConn, Matcher[bool]](),
+		limits:          NewMap[*ssh.ServerConn, UserLimits](),
+		permitListen:    NewMap[*ssh.ServerConn, []string](),
+		aliases:         NewMatcher[string](),
+		bindingAliases:  NewMap[string, Set[string]](),
+		reservedPlayers: NewMap[string, int](),
 	}
 }
 
@@ -38,12 +60,32 @@ func (m *bindingManager) AddConnection(conn *ssh.ServerConn) error {
 	if m.connections.Contains(conn) {
 		return fmt.Errorf("connection already exists")
 	}
+	limits := permissionLimits(conn.Permissions)
+	if limits.MaxConnections > 0 {
+		count := 0
+		_ = m.connections.Each(func(other *ssh.ServerConn, _ Set[string]) error {
+			if other.User() == conn.User() {
+				count++
+			}
+			return nil
+		})
+		if count >= limits.MaxConnections {
+			return fmt.Errorf("too many connections (limit %d)", limits.MaxConnections)
+		}
+	}
 	m.connections.Set(conn, NewSet[string]())
 	validator := NewMatcher[bool]()
 	for domain := range conn.Permissions.Extensions {
+		if isSettingExtension(domain) {
+			continue
+		}
 		_ = validator.Set(domain, true)
 	}
 	m.allowedBindings.Set(conn, validator)
+	m.limits.Set(conn, limits)
+	if permitListen, ok := conn.Permissions.Extensions[extensionPermitListen]; ok {
+		m.permitListen.Set(conn, strings.Split(permitListen, ","))
+	}
 	return nil
 }
 
@@ -61,10 +103,13 @@ func (m *bindingManager) RemoveConnection(conn *ssh.ServerConn) {
 		}
 		go Close(upstream)
 		m.bindings.Remove(binding)
+		m.removeAliases(binding)
 		return nil
 	})
 	m.connections.Remove(conn)
 	m.allowedBindings.Remove(conn)
+	m.limits.Remove(conn)
+	m.permitListen.Remove(conn)
 }
 
 func (m *bindingManager) AddBinding(conn *ssh.ServerConn, pattern string, targetPort uint32) error {
@@ -77,9 +122,25 @@ func (m *bindingManager) AddBinding(conn *ssh.ServerConn, pattern string, target
 	if _, ok := validator.MatchPattern(pattern); !ok {
 		return fmt.Errorf("binding not allowed")
 	}
+	if permitListen, ok := m.permitListen.Get(conn); ok && !permitListenAllows(permitListen, pattern, targetPort) {
+		return fmt.Errorf("binding not permitted for this key")
+	}
 	if m.bindings.Contains(pattern) {
 		return fmt.Errorf("binding already exists")
 	}
+	if m.aliases.Contains(pattern) {
+		return fmt.Errorf("binding already exists as an alias")
+	}
+	limits, _ := m.limits.Get(conn)
+	if limits.MaxBindings > 0 {
+		count := 0
+		m.eachUserConnection(conn.User(), func(_ *ssh.ServerConn, domains Set[string]) {
+			count += domains.Len()
+		})
+		if count >= limits.MaxBindings {
+			return fmt.Errorf("too many bindings (limit %d)", limits.MaxBindings)
+		}
+	}
 	upstream := NewMcUpstream(pattern, conn, targetPort)
 	_ = m.bindings.Set(pattern, upstream)
 	domains, _ := m.connections.Get(conn)
@@ -106,13 +167,115 @@ func (m *bindingManager) EachBinding(conn *ssh.ServerConn, callback func(upstrea
 	})
 }
 
+// EachUserBinding walks the bindings of all SSH connections of user.
+func (m *bindingManager) EachUserBinding(user string, callback func(upstream McUpstream) error) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var err error
+	m.eachUserConnection(user, func(_ *ssh.ServerConn, domains Set[string]) {
+		if err != nil {
+			return
+		}
+		err = domains.Each(func(pattern string) error {
+			upstream, _ := m.bindings.Get(pattern)
+			return callback(upstream)
+		})
+	})
+	return err
+}
+
+// UserAllows reports whether a connection of user may register the pattern,
+// and whether user is connected at all.
+func (m *bindingManager) UserAllows(user string, pattern string) (bool, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	allowed, connected := false, false
+	m.eachUserConnection(user, func(conn *ssh.ServerConn, _ Set[string]) {
+		connected = true
+		validator, _ := m.allowedBindings.Get(conn)
+		if _, ok := validator.MatchPattern(pattern); ok {
+			allowed = true
+		}
+	})
+	return allowed, connected
+}
+
+func (m *bindingManager) EachConnection(callback func(conn *ssh.ServerConn) error) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.connections.Each(func(conn *ssh.ServerConn, _ Set[string]) error {
+		return callback(conn)
+	})
+}
+
 func (m *bindingManager) Resolve(domain string) (McUpstream, bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 	upstream, ok := m.bindings.Get(domain)
+	if !ok {
+		var pattern string
+		if pattern, ok = m.aliases.Get(domain); ok {
+			upstream, ok = m.bindings.Get(pattern)
+		}
+	}
+	if ok && upstream.Draining() {
+		return nil, false
+	}
 	return upstream, ok
 }
 
+// ReservePlayer takes a player connection slot of the owner of the binding,
+// counted across all of their bindings. The slot is held until the returned
+// function is called, by then the connection has to be dialed or given up.
+func (m *bindingManager) ReservePlayer(upstream McUpstream) (func(), error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	limits, _ := m.limits.Get(upstream.SSHConn())
+	if limits.MaxPlayerConnections <= 0 {
+		return func() {}, nil
+	}
+	user := upstream.SSHConn().User()
+	count, _ := m.reservedPlayers.Get(user)
+	m.eachUserConnection(user, func(_ *ssh.ServerConn, domains Set[string]) {
+		_ = domains.Each(func(pattern string) error {
+			if upstream, ok := m.bindings.Get(pattern); ok {
+				count += upstream.GetConnections()
+			}
+			return nil
+		})
+	})
+	if count >= limits.MaxPlayerConnections {
+		return nil, fmt.Errorf("too many player connections (limit %d)", limits.MaxPlayerConnections)
+	}
+	m.addReservedPlayers(user, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			m.addReservedPlayers(user, -1)
+		})
+	}, nil
+}
+
+func (m *bindingManager) addReservedPlayers(user string, delta int) {
+	count, _ := m.reservedPlayers.Get(user)
+	if count+delta == 0 {
+		m.reservedPlayers.Remove(user)
+		return
+	}
+	m.reservedPlayers.Set(user, count+delta)
+}
+
+func (m *bindingManager) eachUserConnection(user string, callback func(conn *ssh.ServerConn, domains Set[string])) {
+	_ = m.connections.Each(func(conn *ssh.ServerConn, domains Set[string]) error {
+		if conn.User() == user {
+			callback(conn, domains)
+		}
+		return nil
+	})
+}
+
 func (m *bindingManager) RemoveBinding(pattern string) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -122,20 +285,126 @@ func (m *bindingManager) RemoveBinding(pattern string) {
 	upstream, _ := m.bindings.Get(pattern)
 	go Close(upstream)
 	m.bindings.Remove(pattern)
+	m.removeAliases(pattern)
 	domains, _ := m.connections.Get(upstream.SSHConn())
 	domains.Remove(pattern)
 }
 
-func (m *bindingManager) SetProxyProtocol(conn *ssh.ServerConn, pattern string, proxyProtocol bool) error {
+// AddAlias routes the hostname alias to the binding registered with exactly
+// this pattern. The alias has to be allowed for the connection just like a
+// binding, and is removed together with the binding.
+func (m *bindingManager) AddAlias(conn *ssh.ServerConn, pattern string, alias string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	if !m.connections.Contains(conn) {
-		return fmt.Errorf("connection does not exist")
+	upstream, err := m.lookup(conn, pattern)
+	if err != nil {
+		return err
 	}
-	if !m.bindings.Contains(pattern) {
-		return fmt.Errorf("binding does not exist")
+	if alias == "" || strings.Contains(alias, "*") {
+		return fmt.Errorf("alias must be a hostname")
+	}
+	validator, _ := m.allowedBindings.Get(conn)
+	if _, ok := validator.MatchPattern(alias); !ok {
+		return fmt.Errorf("alias not allowed")
+	}
+	if permitListen, ok := m.permitListen.Get(conn); ok && !permitListenAllows(permitListen, alias, upstream.TargetPort()) {
+		return fmt.Errorf("alias not permitted for this key")
+	}
+	if m.bindings.Contains(alias) || m.aliases.Contains(alias) {
+		return fmt.Errorf("hostname already in use")
+	}
+	_ = m.aliases.Set(alias, pattern)
+	aliases, ok := m.bindingAliases.Get(pattern)
+	if !ok {
+		aliases = NewSet[string]()
+		m.bindingAliases.Set(pattern, aliases)
+	}
+	aliases.Add(alias)
+	return nil
+}
+
+// RemoveAlias removes an alias of one of the bindings of the connection.
+func (m *bindingManager) RemoveAlias(conn *ssh.ServerConn, alias string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	pattern, ok := m.aliases.Get(alias)
+	if !ok {
+		return fmt.Errorf("alias does not exist")
+	}
+	if _, err := m.lookup(conn, pattern); err != nil {
+		return fmt.Errorf("alias does not exist")
+	}
+	m.aliases.Remove(alias)
+	aliases, _ := m.bindingAliases.Get(pattern)
+	aliases.Remove(alias)
+	return nil
+}
+
+// Aliases returns the aliases of the binding registered with this pattern,
+// sorted.
+func (m *bindingManager) Aliases(pattern string) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	result := []string{}
+	if aliases, ok := m.bindingAliases.Get(pattern); ok {
+		_ = aliases.Each(func(alias string) error {
+			result = append(result, alias)
+			return nil
+		})
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (m *bindingManager) removeAliases(pattern string) {
+	aliases, ok := m.bindingAliases.Get(pattern)
+	if !ok {
+		return
+	}
+	_ = aliases.Each(func(alias string) error {
+		m.aliases.Remove(alias)
+		return nil
+	})
+	m.bindingAliases.Remove(pattern)
+}
+
+func (m *bindingManager) SetProxyProtocol(conn *ssh.ServerConn, pattern string, proxyProtocol bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	upstream, err := m.lookup(conn, pattern)
+	if err != nil {
+		return err
 	}
-	upstream, _ := m.bindings.Get(pattern)
 	upstream.SetProxyProtocol(proxyProtocol)
 	return nil
 }
+
+func (m *bindingManager) SetIdleTimeout(conn *ssh.ServerConn, pattern string, timeout time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	upstream, err := m.lookup(conn, pattern)
+	if err != nil {
+		return err
+	}
+	upstream.SetIdleTimeout(timeout)
+	return nil
+}
+
+// Lookup returns the binding registered with exactly this pattern, as long as
+// it belongs to the given connection.
+func (m *bindingManager) Lookup(conn *ssh.ServerConn, pattern string) (McUpstream, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.lookup(conn, pattern)
+}
+
+func (m *bindingManager) lookup(conn *ssh.ServerConn, pattern string) (McUpstream, error) {
+	if !m.connections.Contains(conn) {
+		return nil, fmt.Errorf("connection does not exist")
+	}
+	upstream, ok := m.bindings.Get(pattern)
+	if !ok || upstream.SSHConn() != conn {
+		return nil, fmt.Errorf("binding does not exist")
+	}
+	return upstream, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"golang.org/x/crypto/ssh"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSSHConn struct {
+	ssh.Conn
+	user       string
+	remoteAddr net.Addr
+	closed     bool
+}
+
+func (c *testSSHConn) User() string {
+	return c.user
+}
+
+func (c *testSSHConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *testSSHConn) SessionID() []byte {
+	return []byte(c.user)
+}
+
+func (c *testSSHConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func newTestServerConn(user string, bindings ...string) *ssh.ServerConn {
+	extensions := map[string]string{}
+	for _, binding := range bindings {
+		extensions[binding] = ""
+	}
+	return &ssh.ServerConn{
+		Conn:        &testSSHConn{user: user},
+		Permissions: &ssh.Permissions{Extensions: extensions},
+	}
+}
+
+func TestSetIdleTimeout(t *testing.T) {
+	opts.IdleTimeout = 30
+	defer func() {
+		opts.IdleTimeout = 0
+	}()
+	manager := NewBindingManager()
+	conn := newTestServerConn("alice", "*.example.com")
+	if err := manager.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddBinding(conn, "a.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	upstream, _ := manager.Resolve("a.example.com")
+	if upstream.IdleTimeout() != 30*time.Second {
+		t.Errorf("Expected the router default of 30s, got %v [FAILED]", upstream.IdleTimeout())
+	}
+	if err := manager.SetIdleTimeout(conn, "a.example.com", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if upstream.IdleTimeout() != time.Second {
+		t.Errorf("Expected the idle timeout to be %v, got %v [FAILED]", time.Second, upstream.IdleTimeout())
+	}
+	upstream.SetIdleTimeout(-1)
+	if upstream.IdleTimeout() != 30*time.Second {
+		t.Errorf("Expected a negative timeout to restore the router default, got %v [FAILED]", upstream.IdleTimeout())
+	}
+	if err := manager.SetIdleTimeout(conn, "b.example.com", time.Second); err == nil {
+		t.Errorf("Expected the idle timeout of a missing binding to be refused [FAILED]")
+	}
+}
+
+func TestSetIdleTimeoutConcurrently(t *testing.T) {
+	upstream := NewMcUpstream("a.example.com", nil, 25565)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			upstream.SetIdleTimeout(time.Second)
+			upstream.SetDraining(true)
+		}()
+		go func() {
+			defer wg.Done()
+			upstream.IdleTimeout()
+			upstream.Draining()
+		}()
+	}
+	wg.Wait()
+	if upstream.IdleTimeout() != time.Second || !upstream.Draining() {
+		t.Errorf("Expected the idle timeout and draining to be set [FAILED]")
+	}
+}
+
+func TestSetIdleTimeoutOwnership(t *testing.T) {
+	manager := NewBindingManager()
+	owner := newTestServerConn("alice", "*.example.com")
+	other := newTestServerConn("bob", "*.example.com")
+	for _, conn := range []*ssh.ServerConn{owner, other} {
+		if err := manager.AddConnection(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := manager.AddBinding(owner, "a.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SetIdleTimeout(other, "a.example.com", time.Second); err == nil {
+		t.Errorf("Expected the idle timeout of another user's binding to be refused [FAILED]")
+	}
+	if err := manager.SetIdleTimeout(owner, "a.example.com", time.Second); err != nil {
+		t.Errorf("Expected the owner to set the idle timeout, got %v [FAILED]", err)
+	}
+	upstream, _ := manager.Resolve("a.example.com")
+	if upstream.IdleTimeout() != time.Second {
+		t.Errorf("Expected the idle timeout to be %v, got %v [FAILED]", time.Second, upstream.IdleTimeout())
+	}
+}
+
+func TestDrainingIsNotResolved(t *testing.T) {
+	manager := NewBindingManager()
+	conn := newTestServerConn("alice", "*.example.com")
+	if err := manager.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddBinding(conn, "a.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := manager.Lookup(conn, "a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream.SetDraining(true)
+	if _, ok := manager.Resolve("a.example.com"); ok {
+		t.Errorf("Expected a draining binding not to get new players [FAILED]")
+	}
+	if _, err := manager.Lookup(conn, "a.example.com"); err != nil {
+		t.Errorf("Expected the owner to still find a draining binding, got %v [FAILED]", err)
+	}
+}
+
+func TestReservePlayer(t *testing.T) {
+	manager := NewBindingManager()
+	conn := newTestServerConn("alice", "*.example.com")
+	conn.Permissions.Extensions[extensionMaxPlayerConnections] = "1"
+	if err := manager.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddBinding(conn, "a.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	upstream, _ := manager.Resolve("a.example.com")
+	release, err := manager.ReservePlayer(upstream)
+	if err != nil {
+		t.Fatalf("Expected the first player to get a slot, got %v [FAILED]", err)
+	}
+	if _, err := manager.ReservePlayer(upstream); err == nil {
+		t.Errorf("Expected a reserved slot to count towards the limit [FAILED]")
+	}
+	release()
+	release()
+	release, err = manager.ReservePlayer(upstream)
+	if err != nil {
+		t.Errorf("Expected a released slot to be free again, got %v [FAILED]", err)
+	} else if _, err := manager.ReservePlayer(upstream); err == nil {
+		t.Errorf("Expected releasing twice to free only one slot [FAILED]")
+	}
+	release()
+}
+
+func TestAliases(t *testing.T) {
+	manager := NewBindingManager()
+	conn := newTestServerConn("alice", "*.example.com")
+	other := newTestServerConn("bob", "*.example.com")
+	for _, c := range []*ssh.ServerConn{conn, other} {
+		if err := manager.AddConnection(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, binding := range []string{"a.example.com", "b.example.com"} {
+		if err := manager.AddBinding(conn, binding, 25565); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := manager.AddAlias(conn, "a.example.com", "play.example.com"); err != nil {
+		t.Fatalf("Expected the alias to be added, got %v [FAILED]", err)
+	}
+	upstream, ok := manager.Resolve("play.example.com")
+	if !ok || upstream.Domain() != "a.example.com" {
+		t.Errorf("Expected the alias to resolve to a.example.com [FAILED]")
+	}
+	for _, alias := range []string{"play.example.com", "b.example.com", "*.example.com", "play.example.org"} {
+		if err := manager.AddAlias(conn, "a.example.com", alias); err == nil {
+			t.Errorf("Expected the alias %s to be refused [FAILED]", alias)
+		}
+	}
+	if err := manager.AddBinding(conn, "play.example.com", 25565); err == nil {
+		t.Errorf("Expected a binding on an alias to be refused [FAILED]")
+	}
+	if err := manager.AddAlias(other, "a.example.com", "other.example.com"); err == nil {
+		t.Errorf("Expected an alias of another user's binding to be refused [FAILED]")
+	}
+	if err := manager.RemoveAlias(other, "play.example.com"); err == nil {
+		t.Errorf("Expected another user to be refused to remove the alias [FAILED]")
+	}
+	if err := manager.AddAlias(conn, "a.example.com", "www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.RemoveAlias(conn, "www.example.com"); err != nil {
+		t.Errorf("Expected the alias to be removed, got %v [FAILED]", err)
+	}
+	if _, ok := manager.Resolve("www.example.com"); ok {
+		t.Errorf("Expected a removed alias not to be resolved [FAILED]")
+	}
+	manager.RemoveBinding("a.example.com")
+	if _, ok := manager.Resolve("play.example.com"); ok {
+		t.Errorf("Expected the alias to be removed with its binding [FAILED]")
+	}
+	if aliases := manager.Aliases("a.example.com"); len(aliases) != 0 {
+		t.Errorf("Expected no aliases to be left, got %v [FAILED]", aliases)
+	}
+	if err := manager.AddBinding(conn, "play.example.com", 25565); err != nil {
+		t.Errorf("Expected the hostname of a removed alias to be free, got %v [FAILED]", err)
+	}
+}
+
+func TestAliasPermitListen(t *testing.T) {
+	manager := NewBindingManager()
+	conn := newTestServerConn("alice", "*.example.com")
+	conn.Permissions.Extensions[extensionPermitListen] = "a.example.com:25565,play.example.com:25566,www.example.com:*"
+	if err := manager.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddBinding(conn, "a.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.AddAlias(conn, "a.example.com", "play.example.com"); err == nil {
+		t.Errorf("Expected an alias on a port not permitted for the key to be refused [FAILED]")
+	}
+	if err := manager.AddAlias(conn, "a.example.com", "b.example.com"); err == nil {
+		t.Errorf("Expected an alias on a host not permitted for the key to be refused [FAILED]")
+	}
+	if err := manager.AddAlias(conn, "a.example.com", "www.example.com"); err != nil {
+		t.Errorf("Expected an alias permitted for the key to be added, got %v [FAILED]", err)
+	}
+}
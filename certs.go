@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// certBindingsExtension lists the bindings a certificate may register as a
+// comma separated list of patterns, e.g. when signing with
+// ssh-keygen -O extension:permit-bindings@mcrouter=example.com,*.example.com
+const certBindingsExtension = "permit-bindings@mcrouter"
+
+const sourceAddressOption = "source-address"
+
+var trustedUserCAs = NewSet[string]()
+
+// loadAuthorities reads the trusted user CA keys and the revocation list
+// configured on the command line.
+func loadAuthorities() error {
+	cas := NewSet[string]()
+	if opts.UserCAKeys != "" {
+		binary, err := os.ReadFile(opts.UserCAKeys)
+		if err != nil {
+			return err
+		}
+		for len(binary) > 0 {
+			var key ssh.PublicKey
+			key, _, _, binary, err = ssh.ParseAuthorizedKey(binary)
+			if err != nil {
+				break
+			}
+			cas.Add(string(key.Marshal()))
+		}
+		if cas.Len() == 0 {
+			return fmt.Errorf("no CA keys found in %s", opts.UserCAKeys)
+		}
+	}
+	revocations := Revocations(&revocationList{})
+	if opts.RevokedKeys != "" {
+		binary, err := os.ReadFile(opts.RevokedKeys)
+		if err != nil {
+			return err
+		}
+		revocations, err = ParseRevocations(binary)
+		if err != nil {
+			return err
+		}
+	}
+	trustedUserCAs = cas
+	revokedKeys = revocations
+	return nil
+}
+
+func handleSSHCertificateAuth(conn ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("not a user certificate")
+	}
+
+	if !trustedUserCAs.Contains(string(cert.SignatureKey.Marshal())) {
+		return nil, fmt.Errorf("certificate authority is not trusted")
+	}
+
+	if len(cert.ValidPrincipals) == 0 {
+		return nil, fmt.Errorf("certificate has no principals")
+	}
+
+	checker := ssh.CertChecker{
+		IsRevoked: func(cert *ssh.Certificate) bool {
+			return revokedKeys.IsRevoked(cert)
+		},
+	}
+
+	err := checker.CheckCert(conn.User(), cert)
+	if err != nil {
+		return nil, err
+	}
+
+	var config *UserConfig
+
+	if extension, ok := cert.Extensions[certBindingsExtension]; ok {
+		// the limits and the TOTP secret of the user still apply when there
+		// is a user config
+		config = &UserConfig{}
+		userConfig, err := loadConfig(conn.User())
+		if err == nil {
+			config.Limits = userConfig.Limits
+			config.TOTPSecret = userConfig.TOTPSecret
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, binding := range strings.Split(extension, ",") {
+			if binding = strings.TrimSpace(binding); binding != "" {
+				config.AllowedBindings = append(config.AllowedBindings, binding)
+			}
+		}
+	} else {
+		config, err = loadConfig(conn.User())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = applyCertificateLimits(cert, &config.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := userPermission(config)
+
+	if sourceAddress, ok := cert.CriticalOptions[sourceAddressOption]; ok {
+		// enforced by the ssh package once authentication succeeds
+		permissions.CriticalOptions = map[string]string{sourceAddressOption: sourceAddress}
+	}
+
+	if config.TOTPSecret != "" {
+		return nil, secondFactor(config, permissions, "certificate", cert.Key)
+	}
+
+	return permissions, nil
+}
+
+// applyCertificateLimits overrides the limits of the user with the
+// max-connections@mcrouter, max-bindings@mcrouter and
+// max-player-connections@mcrouter extensions of the certificate.
+func applyCertificateLimits(cert *ssh.Certificate, limits *UserLimits) error {
+	for extension, limit := range map[string]*int{
+		extensionMaxConnections:       &limits.MaxConnections,
+		extensionMaxBindings:          &limits.MaxBindings,
+		extensionMaxPlayerConnections: &limits.MaxPlayerConnections,
+	} {
+		value, ok := cert.Extensions[extension]
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("invalid certificate extension %s: %s", extension, value)
+		}
+		*limit = parsed
+	}
+	return nil
+}
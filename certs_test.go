@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path"
+	"testing"
+)
+
+func signTestCertificate(t *testing.T, user string, extensions map[string]string) *ssh.Certificate {
+	caPublic, caPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, err := ssh.NewPublicKey(caPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedUserCAs = NewSet[string]()
+	trustedUserCAs.Add(string(caKey.Marshal()))
+	revokedKeys = &revocationList{}
+	userPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := ssh.NewPublicKey(userPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             userKey,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{user},
+		ValidBefore:     ssh.CertTimeInfinity,
+		Permissions:     ssh.Permissions{Extensions: extensions},
+	}
+	err = cert.SignCert(rand.Reader, caSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertificateLimits(t *testing.T) {
+	opts.SSHAuth = t.TempDir()
+	err := os.WriteFile(path.Join(opts.SSHAuth, "carol.yaml"), []byte("max_bindings: 2\nmax_player_connections: 10\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &testSSHConn{user: "carol"}
+	cert := signTestCertificate(t, "carol", map[string]string{
+		certBindingsExtension:         "example.com",
+		extensionMaxPlayerConnections: "3",
+	})
+	permissions, err := handleSSHCertificateAuth(conn, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := permissionLimits(permissions)
+	if limits.MaxBindings != 2 || limits.MaxPlayerConnections != 3 {
+		t.Errorf("Expected the limits of the user config and the certificate to apply, got %+v [FAILED]", limits)
+	}
+	cert = signTestCertificate(t, "carol", map[string]string{extensionMaxBindings: "many"})
+	if _, err := handleSSHCertificateAuth(conn, cert); err == nil {
+		t.Errorf("Expected an invalid limit extension to be rejected [FAILED]")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Potterli20/go-flags-fork"
+	"sort"
+	"strings"
+)
+
+// commandOptions returns the options struct parsed by each command and its
+// aliases, so their flags can be completed without running the command.
+var commandOptions = map[string]func() any{
+	"proxy":       func() any { return &proxyProtoCommandOptions{} },
+	"p":           func() any { return &proxyProtoCommandOptions{} },
+	"drain":       func() any { return &drainCommandOptions{} },
+	"idle":        func() any { return &idleCommandOptions{} },
+	"i":           func() any { return &idleCommandOptions{} },
+	"list":        func() any { return &listCommandOptions{} },
+	"ls":          func() any { return &listCommandOptions{} },
+	"top":         func() any { return &topCommandOptions{} },
+	"connections": func() any { return &connectionsCommandOptions{} },
+	"conns":       func() any { return &connectionsCommandOptions{} },
+	"kill":        func() any { return &killCommandOptions{} },
+	"logs":        func() any { return &logsCommandOptions{} },
+	"ban":         func() any { return &emptyCommandOptions{} },
+	"unban":       func() any { return &emptyCommandOptions{} },
+	"bans":        func() any { return &emptyCommandOptions{} },
+	"script":      func() any { return &emptyCommandOptions{} },
+	"whoami":      func() any { return &emptyCommandOptions{} },
+	"info":        func() any { return &emptyCommandOptions{} },
+	"alias":       func() any { return &emptyCommandOptions{} },
+	"motd":        func() any { return &emptyCommandOptions{} },
+	"favicon":     func() any { return &emptyCommandOptions{} },
+	"admin":       func() any { return &adminCommandOptions{} },
+	"help":        func() any { return &emptyCommandOptions{} },
+	"exit":        func() any { return &emptyCommandOptions{} },
+}
+
+// bindingCompletions returns the bindings a command accepts as arguments,
+// proxy, drain and idle only act on the bindings of the current connection.
+func (s *session) bindingCompletions(command string) []string {
+	var domains []string
+	collect := func(upstream McUpstream) error {
+		domains = append(domains, upstream.Domain())
+		return nil
+	}
+	switch command {
+	case "proxy", "p", "drain", "idle", "i", "alias":
+		_ = bindings.EachBinding(s.conn, collect)
+	case "connections", "conns", "logs", "ban", "unban", "bans", "motd", "favicon":
+		_ = bindings.EachUserBinding(s.conn.User(), collect)
+	}
+	return domains
+}
+
+// complete is the AutoCompleteCallback of interactive sessions. On tab it
+// completes the word before the cursor to a command name, a flag of the
+// command or one of the user's bindings, and lists the candidates when the
+// word is ambiguous.
+func (s *session) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	prefix := line[:pos]
+	start := strings.LastIndexAny(prefix, " \t") + 1
+	word := prefix[start:]
+	words := strings.Fields(prefix[:start])
+	// only the words after the last ; or && belong to the current command
+	for i := len(words) - 1; i >= 0; i-- {
+		if words[i] == "&&" || strings.HasSuffix(words[i], ";") {
+			words = words[i+1:]
+			break
+		}
+	}
+	candidates := s.completionCandidates(words, word)
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return line, pos, true
+	}
+	completed := matches[0]
+	if len(matches) == 1 {
+		completed += " "
+	} else {
+		for _, match := range matches[1:] {
+			completed = completed[:commonPrefixLength(completed, match)]
+		}
+		if completed == word {
+			_, _ = fmt.Fprintln(s.io, strings.Join(matches, "  "))
+		}
+	}
+	return prefix[:start] + completed + line[pos:], start + len(completed), true
+}
+
+// completionCandidates returns everything that may follow the given words,
+// sorted.
+func (s *session) completionCandidates(words []string, word string) []string {
+	var candidates []string
+	if len(words) == 0 {
+		for _, command := range sessionCommands {
+			if command.Name != "admin" || isAdmin(s.conn) {
+				candidates = append(candidates, command.Name)
+			}
+		}
+		candidates = append(candidates, "help")
+		if s.isPty {
+			candidates = append(candidates, "clear")
+		}
+	} else if strings.HasPrefix(word, "-") {
+		if options, ok := commandOptions[words[0]]; ok {
+			candidates = commandFlags(options())
+		}
+	} else if words[0] == "admin" && len(words) == 1 && isAdmin(s.conn) {
+		for _, command := range adminCommands {
+			candidates = append(candidates, command.Name)
+		}
+		candidates = append(candidates, "help")
+	} else if words[0] == "alias" {
+		candidates = s.aliasCompletions(words[1:])
+	} else if words[0] == "motd" || words[0] == "favicon" {
+		if len(words) == 1 {
+			candidates = []string{"clear", "set", "show"}
+		} else if len(words) == 2 {
+			candidates = s.bindingCompletions(words[0])
+		}
+	} else {
+		candidates = s.bindingCompletions(words[0])
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// aliasCompletions completes the subcommand of alias, then the binding of add
+// and list or an existing alias for rm.
+func (s *session) aliasCompletions(words []string) []string {
+	if len(words) == 0 {
+		return []string{"add", "list", "rm"}
+	}
+	domains := s.bindingCompletions("alias")
+	switch words[0] {
+	case "add":
+		if len(words) == 1 {
+			return domains
+		}
+	case "list", "ls":
+		return domains
+	case "rm", "remove":
+		var aliases []string
+		for _, domain := range domains {
+			aliases = append(aliases, bindings.Aliases(domain)...)
+		}
+		return aliases
+	}
+	return nil
+}
+
+// commandFlags returns the short and long flags parseArgs accepts for the
+// options struct.
+func commandFlags(options any) []string {
+	parser := flags.NewParser(options, flags.None)
+	// the help flag is only added once the parser runs
+	names := []string{"-h", "--help"}
+	groups := parser.Groups()
+	for len(groups) > 0 {
+		group := groups[0]
+		groups = append(groups[1:], group.Groups()...)
+		for _, option := range group.Options() {
+			if option.ShortName != 0 {
+				names = append(names, "-"+string(option.ShortName))
+			}
+			if option.LongName != "" {
+				names = append(names, "--"+option.LongName)
+			}
+		}
+	}
+	return names
+}
+
+func commonPrefixLength(a string, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCommandFlags(t *testing.T) {
+	names := NewSet[string]()
+	for _, name := range commandFlags(&logsCommandOptions{}) {
+		names.Add(name)
+	}
+	for _, name := range []string{"-f", "--follow", "-n", "--lines", "--json", "--format", "-h", "--help"} {
+		if !names.Contains(name) {
+			t.Errorf("Expected %s to be completed for logs [FAILED]", name)
+		}
+	}
+}
+
+func TestCompleteFlag(t *testing.T) {
+	s := &session{}
+	cases := map[string]string{
+		"list --a":       "list --all ",
+		"logs --f":       "logs --fo",
+		"kill --ip":      "kill --ip ",
+		"drain -t 5 --j": "drain -t 5 --json ",
+		"unknown --j":    "unknown --j",
+		"list; idle --r": "list; idle --reset ",
+		"idle && ls --a": "idle && ls --all ",
+	}
+	for line, expected := range cases {
+		completed, pos, ok := s.complete(line, len(line), '\t')
+		if !ok || completed != expected || pos != len(expected) {
+			t.Errorf("Expected %q to complete to %q, got %q at %d [FAILED]", line, expected, completed, pos)
+		}
+	}
+	if _, _, ok := s.complete("list", 4, 'a'); ok {
+		t.Errorf("Expected keys other than tab to be ignored [FAILED]")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"time"
+)
+
+// Keys of ssh.Permissions.Extensions recording how the user logged in.
+const (
+	extensionAuthMethod     = "auth-method@mcrouter"
+	extensionKeyFingerprint = "key-fingerprint@mcrouter"
+)
+
+type ConnectionInfo struct {
+	SessionID      string    `json:"session_id"`
+	User           string    `json:"user"`
+	ClientVersion  string    `json:"client_version"`
+	RemoteAddr     string    `json:"remote_addr"`
+	ConnectedAt    time.Time `json:"connected_at"`
+	AuthMethod     string    `json:"auth_method"`
+	KeyFingerprint string    `json:"key_fingerprint,omitempty"`
+}
+
+// connectionInfos holds the metadata of every accepted SSH connection.
+var connectionInfos = NewMap[*ssh.ServerConn, ConnectionInfo]()
+
+func NewConnectionInfo(conn *ssh.ServerConn) ConnectionInfo {
+	return ConnectionInfo{
+		SessionID:      hex.EncodeToString(conn.SessionID()),
+		User:           conn.User(),
+		ClientVersion:  string(conn.ClientVersion()),
+		RemoteAddr:     conn.RemoteAddr().String(),
+		ConnectedAt:    time.Now(),
+		AuthMethod:     conn.Permissions.Extensions[extensionAuthMethod],
+		KeyFingerprint: conn.Permissions.Extensions[extensionKeyFingerprint],
+	}
+}
+
+func (i ConnectionInfo) String() string {
+	auth := i.AuthMethod
+	if i.KeyFingerprint != "" {
+		auth = fmt.Sprintf("%s %s", auth, i.KeyFingerprint)
+	}
+	return fmt.Sprintf("%s@%s (%s, %s)", i.User, i.RemoteAddr, i.ClientVersion, auth)
+}
+
+// withAuthInfo records the authentication method and, for public keys, the
+// fingerprint of the key in the permissions of the connection.
+func withAuthInfo(permissions *ssh.Permissions, method string, key ssh.PublicKey) *ssh.Permissions {
+	permissions.Extensions[extensionAuthMethod] = method
+	if key != nil {
+		permissions.Extensions[extensionKeyFingerprint] = ssh.FingerprintSHA256(key)
+	}
+	return permissions
+}
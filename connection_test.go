@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+func TestConnectionInfo(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client, requests := sshPair(t)
+	go ssh.DiscardRequests(requests)
+	server.Permissions = withAuthInfo(&ssh.Permissions{Extensions: map[string]string{}}, "publickey", key)
+	info := NewConnectionInfo(server)
+	expected := fmt.Sprintf("alice@%s (%s, publickey %s)", client.LocalAddr(), client.ClientVersion(), ssh.FingerprintSHA256(key))
+	if info.String() != expected {
+		t.Errorf("Expected %q, got %q [FAILED]", expected, info.String())
+	}
+	info.KeyFingerprint = ""
+	info.AuthMethod = "password"
+	if !strings.HasSuffix(info.String(), ", password)") {
+		t.Errorf("Expected no fingerprint for a password login, got %q [FAILED]", info.String())
+	}
+
+	connectionInfos.Set(server, NewConnectionInfo(server))
+	defer connectionInfos.Remove(server)
+	s, channel := newTestSession(server, "")
+	s.Exec("whoami")
+	output := channel.Output()
+	for _, line := range []string{"User:", "Session:", "Auth method:", "Key fingerprint:", ssh.FingerprintSHA256(key)} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected whoami to show %q, got %q [FAILED]", line, output)
+		}
+	}
+}
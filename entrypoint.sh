@@ -1,22 +1,28 @@
 #!/bin/sh
 
-# Check if SSH key exists, if not generate it
-if [ ! -f "$SSH_KEY_PATH" ]; then
-  echo "SSH key not found at $SSH_KEY_PATH, generating new key..."
-  # Ensure directory exists
-  mkdir -p "$(dirname "$SSH_KEY_PATH")"
-  # Generate SSH key without passphrase
-  ssh-keygen -t rsa -b 4096 -f "$SSH_KEY_PATH" -N ""
-  echo "SSH key generated successfully."
-fi
-
-ARGS="-S $SSH_LISTEN -M $MINECRAFT_LISTEN -k $SSH_KEY_PATH -a $AUTH_DIR"
+ARGS="-S $SSH_LISTEN -M $MINECRAFT_LISTEN -K $HOST_KEY_DIR -a $AUTH_DIR -s $STATE_FILE -i $IDLE_TIMEOUT --keepalive-interval $KEEPALIVE_INTERVAL --keepalive-count-max $KEEPALIVE_COUNT_MAX"
 
 # Add optional flags based on environment variables
+if [ -n "$SSH_KEY_PATH" ] && [ -f "$SSH_KEY_PATH" ]; then
+  ARGS="$ARGS -k $SSH_KEY_PATH"
+fi
+
 if [ "$BAN_IP" = "true" ]; then
   ARGS="$ARGS -I -D $BAN_DURATION"
 fi
 
+if [ -n "$HOST_CERT" ]; then
+  ARGS="$ARGS -H $HOST_CERT"
+fi
+
+if [ -n "$USER_CA" ]; then
+  ARGS="$ARGS -C $USER_CA"
+fi
+
+if [ -n "$REVOKED_KEYS" ]; then
+  ARGS="$ARGS -r $REVOKED_KEYS"
+fi
+
 if [ "$LOG_REJECTED" = "true" ]; then
   ARGS="$ARGS -R"
 fi
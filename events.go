@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	EventHandshake  = "handshake"
+	EventLogin      = "login"
+	EventRejected   = "rejected"
+	EventDisconnect = "disconnect"
+	EventError      = "error"
+)
+
+// Event is something that happened to a binding, kept so the owner of the
+// binding can see it from their SSH session.
+type Event struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Domain     string    `json:"domain"`
+	Type       string    `json:"type"`
+	RemoteAddr string    `json:"remote_addr"`
+	Message    string    `json:"message"`
+}
+
+type eventSubscriber struct {
+	user   string
+	events chan Event
+}
+
+type eventLog struct {
+	entries     []Event
+	next        int
+	full        bool
+	subscribers map[*eventSubscriber]struct{}
+	lock        sync.Mutex
+}
+
+// EventLog keeps the last events of all bindings in a ring buffer and passes
+// new ones on to subscribers. An empty user means the events of every user.
+type EventLog interface {
+	Add(event Event)
+	Recent(user string, limit int) []Event
+	Subscribe(user string) (<-chan Event, func())
+}
+
+var events = NewEventLog(5000)
+
+func NewEventLog(size int) EventLog {
+	return &eventLog{
+		entries:     make([]Event, size),
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+func (l *eventLog) Add(event Event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries[l.next] = event
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+	for subscriber := range l.subscribers {
+		if subscriber.user != "" && subscriber.user != event.User {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			// the subscriber is too slow, drop the event rather than the router
+		}
+	}
+}
+
+// Recent returns up to limit of the latest events of user, oldest first.
+func (l *eventLog) Recent(user string, limit int) []Event {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	var recent []Event
+	for i := 1; i <= count && len(recent) < limit; i++ {
+		event := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if user == "" || event.User == user {
+			recent = append(recent, event)
+		}
+	}
+	for i, j := 0, len(recent)-1; i < j; i, j = i+1, j-1 {
+		recent[i], recent[j] = recent[j], recent[i]
+	}
+	return recent
+}
+
+// Subscribe returns a channel receiving the new events of user until the
+// returned function is called.
+func (l *eventLog) Subscribe(user string) (<-chan Event, func()) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	subscriber := &eventSubscriber{user: user, events: make(chan Event, 64)}
+	l.subscribers[subscriber] = struct{}{}
+	var once sync.Once
+	return subscriber.events, func() {
+		once.Do(func() {
+			l.lock.Lock()
+			defer l.lock.Unlock()
+			delete(l.subscribers, subscriber)
+			close(subscriber.events)
+		})
+	}
+}
+
+func addEvent(upstream McUpstream, kind string, remoteAddr string, format string, args ...any) {
+	events.Add(Event{
+		Time:       time.Now(),
+		User:       upstream.SSHConn().User(),
+		Domain:     upstream.Domain(),
+		Type:       kind,
+		RemoteAddr: remoteAddr,
+		Message:    fmt.Sprintf(format, args...),
+	})
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s %s %s %s %s", e.Time.Format(time.DateTime), e.Domain, e.Type, e.RemoteAddr, e.Message)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEventLogRecent(t *testing.T) {
+	history := NewEventLog(4)
+	for i := 0; i < 6; i++ {
+		user := "alice"
+		if i%2 == 1 {
+			user = "bob"
+		}
+		history.Add(Event{User: user, Message: fmt.Sprint(i)})
+	}
+	recent := history.Recent("", 10)
+	if len(recent) != 4 || recent[0].Message != "2" || recent[3].Message != "5" {
+		t.Errorf("Expected the last 4 events oldest first, got %v [FAILED]", recent)
+	}
+	recent = history.Recent("alice", 10)
+	if len(recent) != 2 || recent[0].Message != "2" || recent[1].Message != "4" {
+		t.Errorf("Expected the events of alice only, got %v [FAILED]", recent)
+	}
+	recent = history.Recent("", 1)
+	if len(recent) != 1 || recent[0].Message != "5" {
+		t.Errorf("Expected the limit to keep the latest event, got %v [FAILED]", recent)
+	}
+}
+
+func TestEventLogSubscribe(t *testing.T) {
+	history := NewEventLog(16)
+	live, unsubscribe := history.Subscribe("alice")
+	all, unsubscribeAll := history.Subscribe("")
+	defer unsubscribeAll()
+	history.Add(Event{User: "bob", Message: "bob"})
+	history.Add(Event{User: "alice", Message: "alice"})
+	select {
+	case event := <-live:
+		if event.User != "alice" {
+			t.Errorf("Expected only the events of alice, got %v [FAILED]", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the event of alice to be delivered [FAILED]")
+	}
+	if len(all) != 2 {
+		t.Errorf("Expected an empty user to receive every event, got %d [FAILED]", len(all))
+	}
+	unsubscribe()
+	unsubscribe()
+	history.Add(Event{User: "alice", Message: "late"})
+	if _, ok := <-live; ok {
+		t.Errorf("Expected no events after unsubscribing [FAILED]")
+	}
+}
@@ -7,12 +7,12 @@ require (
 	github.com/Tnze/go-mc v1.19.3
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/pires/go-proxyproto v0.7.0
-	golang.org/x/crypto v0.9.0
-	golang.org/x/term v0.9.0
+	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/google/uuid v1.3.0 // indirect
-	golang.org/x/sys v0.9.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
 )
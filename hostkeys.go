@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type hostKeyGenerator struct {
+	name     string
+	keyType  string
+	generate func() (crypto.Signer, error)
+}
+
+var hostKeyGenerators = []hostKeyGenerator{
+	{
+		name:    "ssh_host_ed25519_key",
+		keyType: ssh.KeyAlgoED25519,
+		generate: func() (crypto.Signer, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		},
+	},
+	{
+		name:    "ssh_host_ecdsa_key",
+		keyType: "ecdsa-sha2-",
+		generate: func() (crypto.Signer, error) {
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		},
+	},
+	{
+		name:    "ssh_host_rsa_key",
+		keyType: ssh.KeyAlgoRSA,
+		generate: func() (crypto.Signer, error) {
+			return rsa.GenerateKey(rand.Reader, 3072)
+		},
+	},
+}
+
+// hostKeys holds every loaded host key. Only the first key of each type is
+// used for the key exchange, all of them are announced to clients through
+// hostkeys-00@openssh.com so a replacement can be learned before it is used.
+var hostKeys []ssh.Signer
+
+// hostCerts holds the host certificates paired with their host key.
+var hostCerts []ssh.Signer
+
+func loadHostKeys() error {
+	var signers []ssh.Signer
+	for _, file := range opts.SSHKey {
+		signer, err := readHostKey(file)
+		if err != nil {
+			return err
+		}
+		signers = append(signers, signer)
+	}
+	if opts.HostKeyDir != "" {
+		dirSigners, err := loadHostKeyDir(opts.HostKeyDir)
+		if err != nil {
+			return err
+		}
+		signers = append(signers, dirSigners...)
+	}
+	seen := NewSet[string]()
+	hostKeys = nil
+	for _, signer := range signers {
+		blob := string(signer.PublicKey().Marshal())
+		if seen.Contains(blob) {
+			continue
+		}
+		seen.Add(blob)
+		hostKeys = append(hostKeys, signer)
+	}
+	if len(hostKeys) == 0 {
+		return fmt.Errorf("no host key configured, use --key or --key-dir")
+	}
+	return loadHostCerts()
+}
+
+// loadHostCerts pairs the certificates given with --host-cert, and the
+// *-cert.pub files found in the key directory, with their host key.
+func loadHostCerts() error {
+	files := opts.HostCerts
+	if opts.HostKeyDir != "" {
+		matches, err := filepath.Glob(path.Join(opts.HostKeyDir, "*-cert.pub"))
+		if err != nil {
+			return err
+		}
+		files = append(files, matches...)
+	}
+	hostCerts = nil
+	for _, file := range files {
+		binary, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(binary)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		cert, ok := key.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.HostCert {
+			return fmt.Errorf("%s: not a host certificate", file)
+		}
+		signer := findHostKey(cert.Key.Marshal())
+		if signer == nil {
+			return fmt.Errorf("%s: no host key matches the certificate", file)
+		}
+		if cert.ValidBefore != ssh.CertTimeInfinity && time.Now().Unix() >= int64(cert.ValidBefore) {
+			log.Printf("Host certificate %s has expired", file)
+		}
+		certSigner, err := ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		hostCerts = append(hostCerts, certSigner)
+	}
+	return nil
+}
+
+func loadHostKeyDir(dir string) ([]ssh.Signer, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	var signers []ssh.Signer
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".pub") {
+			continue
+		}
+		signer, err := readHostKey(path.Join(dir, name))
+		if err != nil {
+			log.Printf("Skipping %s: %v", path.Join(dir, name), err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	for _, generator := range hostKeyGenerators {
+		if hasHostKeyType(signers, generator.keyType) {
+			continue
+		}
+		signer, err := generateHostKey(path.Join(dir, generator.name), generator)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func hasHostKeyType(signers []ssh.Signer, keyType string) bool {
+	for _, signer := range signers {
+		if strings.HasPrefix(signer.PublicKey().Type(), keyType) {
+			return true
+		}
+	}
+	return false
+}
+
+func readHostKey(file string) (ssh.Signer, error) {
+	binary, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(binary)
+}
+
+func generateHostKey(file string, generator hostKeyGenerator) (ssh.Signer, error) {
+	if _, err := os.Stat(file); err == nil {
+		return nil, fmt.Errorf("refusing to overwrite %s", file)
+	}
+	key, err := generator.generate()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromSigner(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(file+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0644)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Generated host key %s (%s)", file, ssh.FingerprintSHA256(signer.PublicKey()))
+	return signer, nil
+}
+
+func addHostKeys(config *ssh.ServerConfig) {
+	served := NewSet[string]()
+	for _, signer := range hostKeys {
+		keyType := signer.PublicKey().Type()
+		if served.Contains(keyType) {
+			continue
+		}
+		served.Add(keyType)
+		config.AddHostKey(signer)
+	}
+	for _, signer := range hostCerts {
+		config.AddHostKey(signer)
+	}
+}
+
+func announceHostKeys(sshConn *ssh.ServerConn) {
+	var payload []byte
+	for _, signer := range hostKeys {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{signer.PublicKey().Marshal()})...)
+	}
+	_, _, _ = sshConn.SendRequest("hostkeys-00@openssh.com", false, payload)
+}
+
+// proveHostKeys answers hostkeys-prove-00@openssh.com by signing the session
+// ID with every requested key, so the client knows the router really holds
+// the keys it announced.
+func proveHostKeys(sshConn *ssh.ServerConn, payload []byte) ([]byte, error) {
+	var reply []byte
+	for len(payload) > 0 {
+		var request struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		err := ssh.Unmarshal(payload, &request)
+		if err != nil {
+			return nil, err
+		}
+		payload = request.Rest
+		signer := findHostKey(request.Key)
+		if signer == nil {
+			return nil, fmt.Errorf("unknown host key")
+		}
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{"hostkeys-prove-00@openssh.com", sshConn.SessionID(), request.Key})
+		var signature *ssh.Signature
+		if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+		} else {
+			signature, err = signer.Sign(rand.Reader, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		reply = append(reply, ssh.Marshal(struct{ Signature []byte }{ssh.Marshal(signature)})...)
+	}
+	return reply, nil
+}
+
+func findHostKey(blob []byte) ssh.Signer {
+	for _, signer := range hostKeys {
+		if string(signer.PublicKey().Marshal()) == string(blob) {
+			return signer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadHostKeyDir(t *testing.T) {
+	dir := t.TempDir()
+	generated, err := loadHostKeyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(generated) != len(hostKeyGenerators) {
+		t.Fatalf("Expected a key of every type to be generated, got %d [FAILED]", len(generated))
+	}
+	loaded, err := loadHostKeyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(generated) {
+		t.Fatalf("Expected the generated keys to be loaded again, got %d [FAILED]", len(loaded))
+	}
+	hostKeys = loaded
+	for _, signer := range generated {
+		if findHostKey(signer.PublicKey().Marshal()) == nil {
+			t.Errorf("Expected %s to be kept across restarts [FAILED]", signer.PublicKey().Type())
+		}
+	}
+	// a key given with --key as well is only used once
+	opts.SSHKey = []string{path.Join(dir, "ssh_host_ed25519_key")}
+	opts.HostKeyDir = dir
+	defer func() {
+		opts.SSHKey = nil
+		opts.HostKeyDir = ""
+	}()
+	if err := loadHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostKeys) != len(generated) || hostKeys[0].PublicKey().Type() != ssh.KeyAlgoED25519 {
+		t.Errorf("Expected %d distinct host keys with the --key one first, got %d [FAILED]", len(generated), len(hostKeys))
+	}
+}
+
+func TestProveHostKeys(t *testing.T) {
+	signers, err := loadHostKeyDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKeys = signers
+	conn := newTestServerConn("alice")
+	var payload []byte
+	for _, signer := range signers {
+		payload = append(payload, ssh.Marshal(struct{ Key []byte }{signer.PublicKey().Marshal()})...)
+	}
+	reply, err := proveHostKeys(conn, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, signer := range signers {
+		var proof struct {
+			Signature []byte
+			Rest      []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(reply, &proof); err != nil {
+			t.Fatal(err)
+		}
+		reply = proof.Rest
+		var signature ssh.Signature
+		if err := ssh.Unmarshal(proof.Signature, &signature); err != nil {
+			t.Fatal(err)
+		}
+		data := ssh.Marshal(struct {
+			Request   string
+			SessionID []byte
+			Key       []byte
+		}{"hostkeys-prove-00@openssh.com", conn.SessionID(), signer.PublicKey().Marshal()})
+		if err := signer.PublicKey().Verify(data, &signature); err != nil {
+			t.Errorf("Expected a valid proof for %s, got %v [FAILED]", signer.PublicKey().Type(), err)
+		}
+	}
+	public, _, _ := ed25519.GenerateKey(rand.Reader)
+	unknown, _ := ssh.NewPublicKey(public)
+	if _, err := proveHostKeys(conn, ssh.Marshal(struct{ Key []byte }{unknown.Marshal()})); err == nil {
+		t.Errorf("Expected a key the router does not hold to be refused [FAILED]")
+	}
+}
+
+func writeHostCert(t *testing.T, file string, ca ssh.Signer, key ssh.PublicKey, certType uint32) {
+	cert := &ssh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		ValidPrincipals: []string{"router.example.com"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHostCertificate(t *testing.T) {
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	ca, _ := ssh.NewSignerFromKey(caKey)
+	dir := t.TempDir()
+	opts.HostKeyDir = dir
+	defer func() {
+		opts.HostKeyDir = ""
+		opts.HostCerts = nil
+		hostCerts = nil
+	}()
+	if err := loadHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+	var key ssh.PublicKey
+	for _, signer := range hostKeys {
+		if signer.PublicKey().Type() == ssh.KeyAlgoED25519 {
+			key = signer.PublicKey()
+		}
+	}
+	writeHostCert(t, path.Join(dir, "ssh_host_ed25519_key-cert.pub"), ca, key, ssh.HostCert)
+	if err := loadHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if len(hostCerts) != 1 {
+		t.Fatalf("Expected the certificate in the key directory to be loaded, got %d [FAILED]", len(hostCerts))
+	}
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	addHostKeys(config)
+	server, client := tcpPair(t)
+	defer server.Close()
+	defer client.Close()
+	go func() {
+		_, _, _, _ = ssh.NewServerConn(server, config)
+	}()
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			return string(auth.Marshal()) == string(ca.PublicKey().Marshal())
+		},
+	}
+	conn, _, _, err := ssh.NewClientConn(client, "router.example.com:22", &ssh.ClientConfig{
+		HostKeyCallback:   checker.CheckHostKey,
+		HostKeyAlgorithms: []string{ssh.CertAlgoED25519v01},
+	})
+	if err != nil {
+		t.Fatalf("Expected the client to trust the certified host key, got %v [FAILED]", err)
+	}
+	_ = conn.Close()
+
+	// a user certificate is not presented as host certificate
+	opts.HostCerts = []string{path.Join(t.TempDir(), "user-cert.pub")}
+	writeHostCert(t, opts.HostCerts[0], ca, key, ssh.UserCert)
+	if err := loadHostKeys(); err == nil {
+		t.Errorf("Expected a user certificate to be refused [FAILED]")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"golang.org/x/crypto/ssh"
+	"log"
+	"time"
+)
+
+// roundTrips holds the last keepalive round-trip time of every SSH
+// connection that has answered at least once.
+var roundTrips = NewMap[*ssh.ServerConn, time.Duration]()
+
+func formatRoundTrip(conn *ssh.ServerConn) string {
+	rtt, ok := roundTrips.Get(conn)
+	if !ok {
+		return "-"
+	}
+	return rtt.Round(time.Millisecond / 10).String()
+}
+
+// handleKeepAlive sends a keepalive request every --keepalive-interval
+// seconds and measures how long the client takes to answer. The connection
+// is closed once --keepalive-count-max intervals pass without an answer.
+func handleKeepAlive(sshConn *ssh.ServerConn) {
+	interval := time.Duration(opts.KeepAlive) * time.Second
+	if interval <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = sshConn.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var replies chan time.Duration
+	missed := uint32(0)
+	for {
+		select {
+		case <-done:
+			return
+		case rtt := <-replies:
+			replies = nil
+			missed = 0
+			roundTrips.Set(sshConn, rtt)
+		case <-ticker.C:
+			if replies == nil {
+				replies = sendKeepAlive(sshConn)
+				continue
+			}
+			missed++
+			if opts.KeepAliveMax > 0 && missed >= opts.KeepAliveMax {
+				log.Printf("[SSH] %v did not answer %d keepalives (last rtt %s), closing", hex.EncodeToString(sshConn.SessionID()), missed, formatRoundTrip(sshConn))
+				_ = sshConn.Close()
+				return
+			}
+			log.Printf("[SSH] %v missed keepalive %d/%d", hex.EncodeToString(sshConn.SessionID()), missed, opts.KeepAliveMax)
+		}
+	}
+}
+
+// sendKeepAlive sends a single keepalive request, the returned channel
+// receives the round-trip time once the client answers. Any answer counts,
+// clients usually reply with a failure to requests they do not know.
+func sendKeepAlive(sshConn *ssh.ServerConn) chan time.Duration {
+	replies := make(chan time.Duration, 1)
+	go func() {
+		start := time.Now()
+		_, _, err := sshConn.SendRequest("keepalive@minecraft", true, nil)
+		if err == nil {
+			replies <- time.Since(start)
+		}
+	}()
+	return replies
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+// sshPair connects an SSH client to a server that lets everyone in, the
+// global requests of the server reach the client on the returned channel.
+func sshPair(t *testing.T) (*ssh.ServerConn, ssh.Conn, <-chan *ssh.Request) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+	client, server := tcpPair(t)
+	servers := make(chan *ssh.ServerConn, 1)
+	go func() {
+		conn, channels, requests, err := ssh.NewServerConn(server, config)
+		if err != nil {
+			servers <- nil
+			return
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			for newChannel := range channels {
+				_ = newChannel.Reject(ssh.Prohibited, "")
+			}
+		}()
+		servers <- conn
+	}()
+	clientConn, _, requests, err := ssh.NewClientConn(client, client.RemoteAddr().String(), &ssh.ClientConfig{
+		User:            "alice",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn := <-servers
+	if serverConn == nil {
+		t.Fatal("server handshake failed")
+	}
+	t.Cleanup(func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	})
+	return serverConn, clientConn, requests
+}
+
+func TestKeepAliveRoundTrip(t *testing.T) {
+	opts.KeepAlive = 1
+	opts.KeepAliveMax = 3
+	server, _, requests := sshPair(t)
+	go ssh.DiscardRequests(requests)
+	go handleKeepAlive(server)
+	for deadline := time.Now().Add(5 * time.Second); formatRoundTrip(server) == "-"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the round-trip time to be measured [FAILED]")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if rtt, _ := roundTrips.Get(server); rtt <= 0 || rtt > time.Second {
+		t.Errorf("Expected a local round-trip time below a second, got %v [FAILED]", rtt)
+	}
+}
+
+func TestKeepAliveUnanswered(t *testing.T) {
+	opts.KeepAlive = 1
+	opts.KeepAliveMax = 1
+	// a client that never reads its requests never answers them
+	server, _, _ := sshPair(t)
+	done := make(chan struct{})
+	go func() {
+		handleKeepAlive(server)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected an unanswered keepalive to close the connection [FAILED]")
+	}
+	if err := server.Wait(); err == nil {
+		t.Errorf("Expected the connection to be closed [FAILED]")
+	}
+	if formatRoundTrip(server) != "-" {
+		t.Errorf("Expected no round-trip time without an answer [FAILED]")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// extensionPermitListen carries the permitlisten entries of the key used to
+// log in, as a comma separated list of host:port.
+const extensionPermitListen = "permit-listen@mcrouter"
+
+// KeyOptions are the authorized_keys options mcrouter understands, see
+// AUTHORIZED_KEYS FILE FORMAT in sshd(8).
+type KeyOptions struct {
+	From             []string
+	ExpiryTime       time.Time
+	PermitListen     []string
+	NoPortForwarding bool
+}
+
+func ParseKeyOptions(options []string) (KeyOptions, error) {
+	var parsed KeyOptions
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		if hasValue {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return parsed, fmt.Errorf("invalid value for %s: %s", name, value)
+			}
+			value = unquoted
+		}
+		switch strings.ToLower(name) {
+		case "from":
+			parsed.From = append(parsed.From, strings.Split(value, ",")...)
+		case "expiry-time":
+			expiry, err := parseExpiryTime(value)
+			if err != nil {
+				return parsed, err
+			}
+			parsed.ExpiryTime = expiry
+		case "permitlisten":
+			if !strings.Contains(value, ":") {
+				// a bare port may be bound on any host, like in OpenSSH
+				value = "*:" + value
+			}
+			if _, _, err := net.SplitHostPort(value); err != nil {
+				return parsed, fmt.Errorf("invalid permitlisten: %s", value)
+			}
+			parsed.PermitListen = append(parsed.PermitListen, value)
+		case "no-port-forwarding":
+			parsed.NoPortForwarding = true
+		}
+	}
+	return parsed, nil
+}
+
+// parseExpiryTime accepts YYYYMMDD[HHMM[SS]] in local time, or in UTC with a
+// trailing Z, like OpenSSH.
+func parseExpiryTime(value string) (time.Time, error) {
+	location := time.Local
+	if strings.HasSuffix(value, "Z") || strings.HasSuffix(value, "z") {
+		location = time.UTC
+		value = value[:len(value)-1]
+	}
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(value) != len(layout) {
+			continue
+		}
+		expiry, err := time.ParseInLocation(layout, value, location)
+		if err == nil {
+			return expiry, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry-time: %s", value)
+}
+
+// AllowsAddress checks the from= patterns against the client address. A
+// matching negated pattern always rejects, otherwise one pattern must match.
+func (o KeyOptions) AllowsAddress(ip net.IP) bool {
+	if len(o.From) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	allowed := false
+	for _, pattern := range o.From {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if !matchAddress(pattern, ip) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+func (o KeyOptions) Expired(now time.Time) bool {
+	return !o.ExpiryTime.IsZero() && !now.Before(o.ExpiryTime)
+}
+
+// Apply narrows the permissions of the user to what the key may do.
+func (o KeyOptions) Apply(permissions *ssh.Permissions) *ssh.Permissions {
+	if o.NoPortForwarding {
+		for key := range permissions.Extensions {
+			if !isSettingExtension(key) {
+				delete(permissions.Extensions, key)
+			}
+		}
+	}
+	if len(o.PermitListen) > 0 {
+		permissions.Extensions[extensionPermitListen] = strings.Join(o.PermitListen, ",")
+	}
+	return permissions
+}
+
+func matchAddress(pattern string, ip net.IP) bool {
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		return err == nil && network.Contains(ip)
+	}
+	matched, err := path.Match(pattern, ip.String())
+	return err == nil && matched
+}
+
+// permitListenAllows checks a requested binding against permitlisten entries,
+// hosts are matched like allowed_bindings and the port must be equal or "*".
+func permitListenAllows(entries []string, pattern string, port uint32) bool {
+	for _, entry := range entries {
+		host, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			continue
+		}
+		if entryPort != "*" && entryPort != strconv.FormatUint(uint64(port), 10) {
+			continue
+		}
+		if host == "*" {
+			return true
+		}
+		validator := NewMatcher[bool]()
+		_ = validator.Set(host, true)
+		if _, ok := validator.MatchPattern(pattern); ok {
+			return true
+		}
+	}
+	return false
+}
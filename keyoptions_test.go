@@ -0,0 +1,59 @@
+package main
+
+import (
+	"golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeyOptions(t *testing.T) {
+	line := `from="10.0.0.0/8,192.168.1.*,!10.0.0.1",expiry-time="20300101Z",permitlisten="staging.example.com:25565" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl ci`
+	_, _, rawOptions, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options, err := ParseKeyOptions(rawOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses := map[string]bool{
+		"10.1.2.3":    true,
+		"10.0.0.1":    false,
+		"192.168.1.7": true,
+		"192.168.2.7": false,
+	}
+	for address, allowed := range addresses {
+		if options.AllowsAddress(net.ParseIP(address)) != allowed {
+			t.Errorf("Expected %s to be allowed: %t [FAILED]", address, allowed)
+		}
+	}
+	if options.Expired(time.Date(2029, 12, 31, 23, 59, 59, 0, time.UTC)) {
+		t.Errorf("Expected key to be valid before expiry-time [FAILED]")
+	}
+	if !options.Expired(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected key to be expired at expiry-time [FAILED]")
+	}
+	if !permitListenAllows(options.PermitListen, "staging.example.com", 25565) {
+		t.Errorf("Expected staging.example.com:25565 to be permitted [FAILED]")
+	}
+	if permitListenAllows(options.PermitListen, "staging.example.com", 25566) {
+		t.Errorf("Expected staging.example.com:25566 not to be permitted [FAILED]")
+	}
+	if permitListenAllows(options.PermitListen, "example.com", 25565) {
+		t.Errorf("Expected example.com:25565 not to be permitted [FAILED]")
+	}
+	options, err = ParseKeyOptions([]string{`permitlisten="25565"`})
+	if err != nil {
+		t.Fatalf("Expected a bare port to be accepted, got %v [FAILED]", err)
+	}
+	if !permitListenAllows(options.PermitListen, "example.com", 25565) || !permitListenAllows(options.PermitListen, "*.example.com", 25565) {
+		t.Errorf("Expected a bare port to permit every host [FAILED]")
+	}
+	if permitListenAllows(options.PermitListen, "example.com", 25566) {
+		t.Errorf("Expected a bare port to permit only that port [FAILED]")
+	}
+	if _, err := ParseKeyOptions([]string{`expiry-time="tomorrow"`}); err == nil {
+		t.Errorf("Expected invalid expiry-time to be rejected [FAILED]")
+	}
+}
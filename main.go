@@ -6,6 +6,8 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -19,41 +21,60 @@ type replyPort struct {
 }
 
 var opts struct {
-	SSHListen       string   `short:"S" name:"ssh" description:"SSH listen address" default:"127.0.0.1:2222"`
-	MinecraftListen string   `short:"M" name:"minecraft" description:"Minecraft listen address" default:"127.0.0.1:25565"`
-	SSHKey          string   `short:"k" name:"key" description:"SSH Server private key file" required:"yes"`
-	SSHAuth         string   `short:"a" name:"auth" description:"SSH Server auth directories" default:"users"`
-	BanIP           bool     `short:"I" name:"ban-ip" description:"Ban IP addresses that tried to ping minecraft server directly"`
-	BanDuration     uint32   `short:"D" name:"ban-duration" description:"Ban duration in hours" default:"48"`
-	LogRejected     bool     `short:"R" name:"rejected" description:"Log rejected connections"`
-	AllowedDomains  []string `short:"w" name:"whitelist" description:"Domain names allowed to connect"`
-	DeniedDomains   []string `short:"b" name:"blacklist" description:"Domain names denied to connect"`
+	SSHListen       string   `short:"S" long:"ssh" description:"SSH listen address" default:"127.0.0.1:2222"`
+	MinecraftListen string   `short:"M" long:"minecraft" description:"Minecraft listen address" default:"127.0.0.1:25565"`
+	SSHKey          []string `short:"k" long:"key" description:"SSH Server private key file"`
+	HostKeyDir      string   `short:"K" long:"key-dir" description:"SSH Server host key directory, missing keys are generated"`
+	HostCerts       []string `short:"H" long:"host-cert" description:"SSH Server host certificate file"`
+	SSHAuth         string   `short:"a" long:"auth" description:"SSH Server auth directories" default:"users"`
+	BanIP           bool     `short:"I" long:"ban-ip" description:"Ban IP addresses that tried to ping minecraft server directly"`
+	BanDuration     uint32   `short:"D" long:"ban-duration" description:"Ban duration in hours" default:"48"`
+	LogRejected     bool     `short:"R" long:"rejected" description:"Log rejected connections"`
+	AllowedDomains  []string `short:"w" long:"whitelist" description:"Domain names allowed to connect"`
+	DeniedDomains   []string `short:"b" long:"blacklist" description:"Domain names denied to connect"`
+	StateFile       string   `short:"s" long:"state" description:"File to persist traffic usage" default:"state.json"`
+	IdleTimeout     uint32   `short:"i" long:"idle-timeout" description:"Close player connections idle for this many seconds, 0 to disable" default:"0"`
+	UserCAKeys      string   `short:"C" long:"user-ca" description:"File of CA public keys trusted to sign user certificates"`
+	RevokedKeys     string   `short:"r" long:"revoked-keys" description:"KRL or serial list of revoked keys and certificates"`
+	AuthMaxIP       uint32   `long:"auth-max-ip" description:"Failed SSH logins from an IP before it is banned, 0 to disable" default:"10"`
+	AuthMaxUser     uint32   `long:"auth-max-user" description:"Failed SSH logins for a username before it is locked, 0 to disable" default:"20"`
+	AuthWindow      uint32   `long:"auth-window" description:"Window in minutes in which failed SSH logins are counted" default:"10"`
+	AuthBanDuration uint32   `long:"auth-ban-duration" description:"Duration in minutes of SSH bans and user locks" default:"30"`
+	KeepAlive       uint32   `long:"keepalive-interval" description:"Seconds between keepalive requests to SSH clients, 0 to disable" default:"5"`
+	KeepAliveMax    uint32   `long:"keepalive-count-max" description:"Keepalive intervals without an answer before an SSH client is disconnected, 0 to never disconnect" default:"3"`
 }
 
 var bindings BindingManager
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		runHashPassword(os.Args[2:])
+		return
+	}
+
 	_, err := flags.Parse(&opts)
 	if err != nil {
 		return
 	}
 
-	keyBin, err := os.ReadFile(opts.SSHKey)
+	err = loadHostKeys()
 	if err != nil {
-		log.Fatalf("Failed to read SSH private key: %v", err)
+		log.Fatalf("Failed to load SSH host keys: %v", err)
 	}
 
-	privateKey, err := ssh.ParsePrivateKey(keyBin)
+	err = loadAuthorities()
 	if err != nil {
-		log.Fatalf("Failed to parse SSH private key: %v", err)
+		log.Fatalf("Failed to load certificate authorities: %v", err)
 	}
 
 	config := &ssh.ServerConfig{
-		PublicKeyCallback: handleSSHPublicKeyAuth,
-		PasswordCallback:  handlePasswordAuth,
+		PublicKeyCallback:           handleSSHPublicKeyAuth,
+		PasswordCallback:            handlePasswordAuth,
+		KeyboardInteractiveCallback: handleKeyboardInteractiveAuth,
+		AuthLogCallback:             handleAuthLog,
 	}
 
-	config.AddHostKey(privateKey)
+	addHostKeys(config)
 
 	sshListener, err := net.Listen("tcp", opts.SSHListen)
 
@@ -80,10 +101,16 @@ func main() {
 
 	bindings = NewBindingManager()
 
-	if opts.BanIP {
-		go cleanupBan()
+	err = loadState(opts.StateFile)
+	if err != nil {
+		log.Fatalf("Failed to load state from %s: %v", opts.StateFile, err)
 	}
 
+	go persistState()
+	go handleShutdown()
+
+	go cleanupBan()
+
 	go listenMinecraft(minecraftListener)
 	listenSSH(sshListener, config)
 }
@@ -95,6 +122,16 @@ func listenSSH(listener net.Listener, config *ssh.ServerConfig) {
 			log.Fatalf("Failed to accept incoming connection: %v", err)
 		}
 
+		if ip := remoteIP(conn.RemoteAddr()); ip != nil {
+			if ban, ok := sshBanList.Check(ip); ok {
+				_ = conn.Close()
+				if opts.LogRejected {
+					log.Printf("[SSH] rejected banned connection from %s, until %v (%s)", conn.RemoteAddr().String(), ban.Until, ban.Reason)
+				}
+				continue
+			}
+		}
+
 		go handleSSH(conn, config)
 	}
 }
@@ -113,8 +150,21 @@ func listenMinecraft(listener net.Listener) {
 func cleanupBan() {
 	for {
 		time.Sleep(5 * time.Minute)
-		_ = banList.Filter(func(ip string, until time.Time) (bool, error) {
-			return until.After(time.Now()), nil
-		})
+		banList.Cleanup()
+		sshBanList.Cleanup()
+		CleanupBindingBans()
+		authGuards.Cleanup()
+		CleanupServerStatuses()
+	}
+}
+
+func handleShutdown() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	err := saveState(opts.StateFile)
+	if err != nil {
+		log.Printf("Failed to save state to %s: %v", opts.StateFile, err)
 	}
+	os.Exit(0)
 }
@@ -5,6 +5,8 @@ import (
 	proxyproto "github.com/pires/go-proxyproto"
 	"golang.org/x/crypto/ssh"
 	"net"
+	"sort"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,40 +18,76 @@ type forwardedTCPPayload struct {
 }
 
 type forwardedConn struct {
+	id            uint64
+	source        net.Addr
+	player        string
+	since         time.Time
 	remoteAddr    net.Addr
 	localAddr     net.Addr
 	channel       ssh.Channel
 	readDeadline  time.Time
 	writeDeadline time.Time
+	traffic       Traffic
+	onTraffic     func(in int, out int)
 	onClose       func(self *forwardedConn)
 }
 
+// PlayerConnection describes an active player connection of a binding,
+// BytesIn is sent by the player and BytesOut by the backend.
+type PlayerConnection struct {
+	ID         uint64    `json:"id"`
+	Domain     string    `json:"domain"`
+	RemoteAddr string    `json:"remote_addr"`
+	Player     string    `json:"player,omitempty"`
+	Since      time.Time `json:"since"`
+	BytesIn    uint64    `json:"bytes_in"`
+	BytesOut   uint64    `json:"bytes_out"`
+}
+
+// playerConnectionIDs numbers player connections across all bindings, so an
+// ID is enough to find one.
+var playerConnectionIDs atomic.Uint64
+
 type mcUpstream struct {
 	closed        bool
+	draining      atomic.Bool
 	domain        string
 	targetPort    uint32
 	sshConn       *ssh.ServerConn
-	connections   Set[net.Conn]
+	connections   Set[*forwardedConn]
 	proxyProtocol bool
+	idleTimeout   atomic.Int64
+	traffic       Traffic
 }
 
 type McUpstream interface {
 	Domain() string
 	SSHConn() *ssh.ServerConn
+	TargetPort() uint32
 	Close() error
-	Dial(src net.Conn) (net.Conn, error)
+	Dial(src net.Conn, player string) (net.Conn, error)
 	UseProxyProtocol() bool
 	SetProxyProtocol(use bool)
+	IdleTimeout() time.Duration
+	SetIdleTimeout(timeout time.Duration)
 	GetConnections() int
+	Connections() []PlayerConnection
+	Kill(id uint64) bool
+	KillIP(network *net.IPNet) int
+	Draining() bool
+	SetDraining(draining bool)
+	Traffic() TrafficStats
 }
 
 func NewMcUpstream(domain string, sshConn *ssh.ServerConn, targetPort uint32) McUpstream {
-	return &mcUpstream{
+	upstream := &mcUpstream{
 		domain:      domain,
 		sshConn:     sshConn,
 		targetPort:  targetPort,
-		connections: NewSet[net.Conn](),
+		connections: NewSet[*forwardedConn](),
 	}
+	upstream.idleTimeout.Store(-1)
+	return upstream
 }
 
 func (m *mcUpstream) Domain() string {
@@ -60,13 +98,19 @@ func (m *mcUpstream) SSHConn() *ssh.ServerConn {
 	return m.sshConn
 }
 
+func (m *mcUpstream) TargetPort() uint32 {
+	return m.targetPort
+}
+
+// Close drops every player connection of this binding, the SSH connection
+// stays open so the client can keep its other bindings.
 func (m *mcUpstream) Close() error {
 	if m.closed {
 		return nil
 	}
 	m.closed = true
 	go closeConnections(m.connections)
-	return m.sshConn.Close()
+	return nil
 }
 
 func (m *mcUpstream) UseProxyProtocol() bool {
@@ -77,11 +121,94 @@ func (m *mcUpstream) SetProxyProtocol(use bool) {
 	m.proxyProtocol = use
 }
 
+// IdleTimeout returns the idle timeout of this binding, falling back to the
+// router default when no override is set.
+func (m *mcUpstream) IdleTimeout() time.Duration {
+	timeout := time.Duration(m.idleTimeout.Load())
+	if timeout < 0 {
+		return time.Duration(opts.IdleTimeout) * time.Second
+	}
+	return timeout
+}
+
+// SetIdleTimeout overrides the idle timeout of this binding, a negative
+// timeout restores the router default.
+func (m *mcUpstream) SetIdleTimeout(timeout time.Duration) {
+	m.idleTimeout.Store(int64(timeout))
+}
+
+func (m *mcUpstream) Draining() bool {
+	return m.draining.Load()
+}
+
+func (m *mcUpstream) SetDraining(draining bool) {
+	m.draining.Store(draining)
+}
+
 func (m *mcUpstream) GetConnections() int {
 	return m.connections.Len()
 }
 
-func (m *mcUpstream) Dial(src net.Conn) (net.Conn, error) {
+func (m *mcUpstream) Traffic() TrafficStats {
+	return m.traffic.Stats()
+}
+
+// Connections returns the active player connections, oldest first.
+func (m *mcUpstream) Connections() []PlayerConnection {
+	connections := []PlayerConnection{}
+	_ = m.connections.Each(func(conn *forwardedConn) error {
+		traffic := conn.traffic.Stats()
+		connections = append(connections, PlayerConnection{
+			ID:         conn.id,
+			Domain:     m.domain,
+			RemoteAddr: conn.source.String(),
+			Player:     conn.player,
+			Since:      conn.since,
+			BytesIn:    traffic.BytesIn,
+			BytesOut:   traffic.BytesOut,
+		})
+		return nil
+	})
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].ID < connections[j].ID
+	})
+	return connections
+}
+
+// Kill closes the player connection with the given ID, the forwarding of
+// the player side stops as soon as the upstream side is gone.
+func (m *mcUpstream) Kill(id uint64) bool {
+	var found *forwardedConn
+	_ = m.connections.Each(func(conn *forwardedConn) error {
+		if conn.id == id {
+			found = conn
+		}
+		return nil
+	})
+	if found == nil {
+		return false
+	}
+	_ = found.Close()
+	return true
+}
+
+// KillIP closes every player connection coming from network and returns how
+// many were closed.
+func (m *mcUpstream) KillIP(network *net.IPNet) int {
+	var found []*forwardedConn
+	_ = m.connections.Each(func(conn *forwardedConn) error {
+		if ip := remoteIP(conn.source); ip != nil && network.Contains(ip) {
+			found = append(found, conn)
+		}
+		return nil
+	})
+	for _, conn := range found {
+		_ = conn.Close()
+	}
+	return len(found)
+}
+
+func (m *mcUpstream) Dial(src net.Conn, player string) (net.Conn, error) {
 	if m.closed {
 		return nil, fmt.Errorf("upstream closed")
 	}
@@ -106,6 +233,10 @@ func (m *mcUpstream) Dial(src net.Conn) (net.Conn, error) {
 	}
 	go ssh.DiscardRequests(reqs)
 	conn := &forwardedConn{
+		id:     playerConnectionIDs.Add(1),
+		source: src.RemoteAddr(),
+		player: player,
+		since:  time.Now(),
 		remoteAddr: &net.TCPAddr{
 			IP:   net.IPv4zero,
 			Port: 0,
@@ -113,6 +244,13 @@ func (m *mcUpstream) Dial(src net.Conn) (net.Conn, error) {
 		localAddr: src.LocalAddr(),
 		channel:   channel,
 	}
+	user := usage.User(m.sshConn.User())
+	conn.onTraffic = func(in int, out int) {
+		m.traffic.AddIn(in)
+		m.traffic.AddOut(out)
+		user.AddIn(in)
+		user.AddOut(out)
+	}
 	if m.proxyProtocol {
 		header := proxyproto.HeaderProxyFromAddrs(1, src.RemoteAddr(), src.LocalAddr())
 		_, err = header.WriteTo(conn)
@@ -125,21 +263,40 @@ func (m *mcUpstream) Dial(src net.Conn) (net.Conn, error) {
 		m.connections.Remove(self)
 	}
 	m.connections.Add(conn)
+	m.traffic.AddConnection()
+	user.AddConnection()
 	return conn, nil
 }
 
-func (f *forwardedConn) Read(b []byte) (int, error) {
+func (f *forwardedConn) Read(b []byte) (n int, err error) {
 	if f.readDeadline.IsZero() {
-		return f.channel.Read(b)
+		n, err = f.channel.Read(b)
+	} else {
+		n, err = readWithDeadline(f.channel, b, f.readDeadline)
 	}
-	return readWithDeadline(f.channel, b, f.readDeadline)
+	f.count(0, n)
+	return n, err
 }
 
-func (f *forwardedConn) Write(b []byte) (int, error) {
+func (f *forwardedConn) Write(b []byte) (n int, err error) {
 	if f.writeDeadline.IsZero() {
-		return f.channel.Write(b)
+		n, err = f.channel.Write(b)
+	} else {
+		n, err = writeWithDeadline(f.channel, b, f.writeDeadline)
+	}
+	f.count(n, 0)
+	return n, err
+}
+
+func (f *forwardedConn) count(in int, out int) {
+	if in == 0 && out == 0 {
+		return
+	}
+	f.traffic.AddIn(in)
+	f.traffic.AddOut(out)
+	if f.onTraffic != nil {
+		f.onTraffic(in, out)
 	}
-	return writeWithDeadline(f.channel, b, f.writeDeadline)
 }
 
 func (f *forwardedConn) Close() error {
@@ -149,6 +306,11 @@ func (f *forwardedConn) Close() error {
 	return f.channel.Close()
 }
 
+// CloseWrite sends EOF to the backend, which may still answer.
+func (f *forwardedConn) CloseWrite() error {
+	return f.channel.CloseWrite()
+}
+
 func (f *forwardedConn) LocalAddr() net.Addr {
 	return f.localAddr
 }
@@ -219,9 +381,15 @@ func writeWithDeadline(channel ssh.Channel, buffer []byte, deadline time.Time) (
 	}
 }
 
-func closeConnections(connections Set[net.Conn]) {
-	_ = connections.Each(func(conn net.Conn) error {
-		_ = conn.Close()
+// closeConnections closes the connections outside of Each, closing one
+// removes it from the set.
+func closeConnections(connections Set[*forwardedConn]) {
+	var found []*forwardedConn
+	_ = connections.Each(func(conn *forwardedConn) error {
+		found = append(found, conn)
 		return nil
 	})
+	for _, conn := range found {
+		_ = conn.Close()
+	}
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// addTestConnection adds a player connection to upstream, closing it closes
+// the returned channel.
+func addTestConnection(upstream McUpstream) *testChannel {
+	m := upstream.(*mcUpstream)
+	channel := newTestChannel()
+	conn := &forwardedConn{channel: channel}
+	conn.onClose = func(self *forwardedConn) {
+		m.connections.Remove(self)
+	}
+	m.connections.Add(conn)
+	return channel
+}
+
+func TestCloseDropsConnections(t *testing.T) {
+	upstream := NewMcUpstream("a.example.com", newTestServerConn("alice"), 25565)
+	channels := []*testChannel{addTestConnection(upstream), addTestConnection(upstream)}
+	_ = upstream.Close()
+	for _, channel := range channels {
+		select {
+		case <-channel.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected closing the binding to close its player connections [FAILED]")
+		}
+	}
+	if upstream.GetConnections() != 0 {
+		t.Errorf("Expected no connections left, got %d [FAILED]", upstream.GetConnections())
+	}
+}
@@ -2,33 +2,36 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/Tnze/go-mc/net/packet"
+	"io"
 	"log"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	ActionLogin = 2
+	ActionStatus = 1
+	ActionLogin  = 2
 )
 
 type McMessage struct {
 	Text string `json:"text"`
 }
 
-var banList = NewMap[string, time.Time]()
 var allowedDomains = NewMatcher[bool]()
 var deniedDomains = NewMatcher[bool]()
 
 func handleMinecraft(downstream net.Conn) {
-	if tcpAddr, ok := downstream.RemoteAddr().(*net.TCPAddr); ok {
-		until, ok := banList.Get(tcpAddr.IP.String())
-		if ok && until.After(time.Now()) {
+	if ip := remoteIP(downstream.RemoteAddr()); ip != nil {
+		ban, ok := banList.Check(ip)
+		if ok {
 			_ = downstream.Close()
 			if opts.LogRejected {
 				log.Printf(
-					"[MC] rejected banned connection from %s, until %v",
-					downstream.RemoteAddr().String(), until,
+					"[MC] rejected banned connection from %s, until %v (%s)",
+					downstream.RemoteAddr().String(), ban.Until, ban.Reason,
 				)
 			}
 			return
@@ -75,7 +78,7 @@ func handleMinecraft(downstream net.Conn) {
 
 	if opts.BanIP && net.ParseIP(string(Host)) != nil {
 		log.Printf("[MC] %s is trying to access directly to an IP address", downstream.RemoteAddr().String())
-		ban(downstream)
+		ban(downstream, "direct IP access")
 		return
 	}
 
@@ -93,17 +96,18 @@ func handleMinecraft(downstream net.Conn) {
 			"[MC] %s is trying to access to %s, but it is not allowed",
 			downstream.RemoteAddr().String(), string(Host),
 		)
-		ban(downstream)
+		ban(downstream, "domain not allowed")
 		return
 	}
 
+	action := "PING"
+	if NextStep == ActionLogin {
+		action = "LOGIN"
+	}
+
 	upstream, ok := bindings.Resolve(string(Host))
 
 	if !ok {
-		action := "PING"
-		if NextStep == ActionLogin {
-			action = "LOGIN"
-		}
 		log.Printf(
 			"[MC] Failed handshake from %s for %s:%d (Protocol %d, %s)",
 			downstream.RemoteAddr().String(),
@@ -111,17 +115,69 @@ func handleMinecraft(downstream net.Conn) {
 		)
 		if NextStep == ActionLogin {
 			kick(downstream, "Server is not available")
+		} else if status, found := LookupServerStatus(string(Host), nil); found && NextStep == ActionStatus {
+			_ = answerStatus(downstream, int32(Version), status)
 		}
 		_ = downstream.Close()
 		return
 	}
 
-	upConn, err := upstream.Dial(downstream)
+	if ip := remoteIP(downstream.RemoteAddr()); ip != nil {
+		if list, ok := BindingBans(upstream, false); ok {
+			if ban, banned := list.Check(ip); banned {
+				if opts.LogRejected {
+					log.Printf(
+						"[MC] rejected %s for %s, banned by owner until %v (%s)",
+						downstream.RemoteAddr().String(), upstream.Domain(), ban.Until, ban.Reason,
+					)
+				}
+				addEvent(upstream, EventRejected, downstream.RemoteAddr().String(), "banned until %v (%s)", ban.Until.Format(time.DateTime), ban.Reason)
+				if NextStep == ActionLogin {
+					kick(downstream, "You are banned from this server: "+ban.Reason)
+				}
+				_ = downstream.Close()
+				return
+			}
+		}
+	}
+
+	release, err := bindings.ReservePlayer(upstream)
+
+	if err != nil {
+		log.Printf("[MC] Rejected %s for %s: %v", downstream.RemoteAddr().String(), upstream.Domain(), err)
+		addEvent(upstream, EventRejected, downstream.RemoteAddr().String(), "%v", err)
+		if NextStep == ActionLogin {
+			kick(downstream, "Server is full")
+		}
+		_ = downstream.Close()
+		return
+	}
+
+	var (
+		loginStart *packet.Packet
+		player     string
+	)
+
+	if NextStep == ActionLogin {
+		loginStart, player, err = readLoginStart(downstream)
+		if err != nil {
+			release()
+			log.Printf("[MC] Failed to read login start from %s: %v", downstream.RemoteAddr().String(), err)
+			_ = downstream.Close()
+			return
+		}
+	}
+
+	upConn, err := upstream.Dial(downstream, player)
+	release()
 
 	if err != nil {
 		log.Printf("[MC] Failed to connect upstream %s, %v", upstream.Domain(), err)
+		addEvent(upstream, EventError, downstream.RemoteAddr().String(), "failed to connect upstream: %v", err)
 		if NextStep == ActionLogin {
 			kick(downstream, "Server is not available")
+		} else if status, found := LookupServerStatus(string(Host), upstream); found && NextStep == ActionStatus {
+			_ = answerStatus(downstream, int32(Version), status)
 		}
 		_ = downstream.Close()
 		return
@@ -129,13 +185,45 @@ func handleMinecraft(downstream net.Conn) {
 
 	_ = p.Pack(upConn, -1)
 
-	forward(downstream, upConn)
+	addEvent(upstream, EventHandshake, downstream.RemoteAddr().String(), "protocol %d, %s", Version, action)
+
+	if loginStart != nil {
+		_ = loginStart.Pack(upConn, -1)
+		addEvent(upstream, EventLogin, downstream.RemoteAddr().String(), "%s", player)
+	}
+
+	reason := forward(downstream, upConn, upstream.IdleTimeout())
+
+	log.Printf(
+		"[MC] %s disconnected from %s: %s",
+		downstream.RemoteAddr().String(), upstream.Domain(), reason,
+	)
+	addEvent(upstream, EventDisconnect, downstream.RemoteAddr().String(), "%s", reason)
+}
+
+// readLoginStart reads the packet following a login handshake, which starts
+// with the name of the player in every protocol version.
+func readLoginStart(downstream net.Conn) (*packet.Packet, string, error) {
+	_ = downstream.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer func() {
+		_ = downstream.SetReadDeadline(time.Time{})
+	}()
+	loginStart := &packet.Packet{}
+	err := loginStart.UnPack(downstream, -1)
+	if err != nil {
+		return nil, "", err
+	}
+	var name packet.String
+	if loginStart.ID != 0 || loginStart.Scan(&name) != nil {
+		return loginStart, "", nil
+	}
+	return loginStart, string(name), nil
 }
 
-func ban(downstream net.Conn) {
+func ban(downstream net.Conn, reason string) {
 	_ = downstream.Close()
-	if tcpAddr, ok := downstream.RemoteAddr().(*net.TCPAddr); ok {
-		banList.Set(tcpAddr.IP.String(), time.Now().Add(time.Duration(opts.BanDuration)*time.Hour))
+	if ip := remoteIP(downstream.RemoteAddr()); ip != nil {
+		_ = banList.Add(ip.String(), time.Now().Add(time.Duration(opts.BanDuration)*time.Hour), reason)
 	}
 }
 
@@ -147,28 +235,77 @@ func kick(conn net.Conn, message string) {
 	time.Sleep(10 * time.Millisecond)
 }
 
-func forward(src net.Conn, dest net.Conn) {
-	closed := make(chan bool)
-	go pipeTo(closed, src, dest)
-	pipeTo(nil, dest, src)
-	<-closed
+// forward copies data both ways until both sides are done sending, one of
+// them fails or the idle timeout fires. A side that stops sending only
+// closes the write side of the other one, so data still flowing the other way
+// is not lost.
+func forward(src net.Conn, dest net.Conn, idleTimeout time.Duration) string {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	reasons := make(chan string, 3)
+	finished := make(chan string, 2)
+	stop := make(chan bool)
+	go pipeTo(finished, reasons, &lastActive, src, "client", dest, "upstream")
+	go pipeTo(finished, reasons, &lastActive, dest, "upstream", src, "client")
+	if idleTimeout > 0 {
+		go watchIdle(reasons, stop, &lastActive, idleTimeout)
+	}
+	var reason string
+	for first := ""; reason == ""; {
+		select {
+		case reason = <-reasons:
+		case finish := <-finished:
+			if first != "" {
+				reason = first
+			}
+			first = finish
+		}
+	}
+	close(stop)
 	_ = src.Close()
 	_ = dest.Close()
+	return reason
 }
 
-func pipeTo(closed chan bool, src net.Conn, dest net.Conn) {
+// pipeTo copies src to dest. Once src is done sending the write side of dest
+// is closed and the result goes to finished, failures go to reasons.
+func pipeTo(finished chan<- string, reasons chan<- string, lastActive *atomic.Int64, src net.Conn, srcName string, dest net.Conn, destName string) {
 	buf := make([]byte, 16384)
 	for {
 		n, err := src.Read(buf)
+		if err == io.EOF {
+			if closer, ok := dest.(interface{ CloseWrite() error }); ok && closer.CloseWrite() == nil {
+				finished <- srcName + " closed"
+				return
+			}
+		}
 		if err != nil {
-			break
+			reasons <- srcName + " closed"
+			return
 		}
+		lastActive.Store(time.Now().UnixNano())
 		_, err = dest.Write(buf[:n])
 		if err != nil {
-			break
+			reasons <- destName + " closed"
+			return
 		}
 	}
-	if closed != nil {
-		closed <- true
+}
+
+func watchIdle(reasons chan<- string, stop <-chan bool, lastActive *atomic.Int64, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, lastActive.Load()))
+			if idle >= timeout {
+				reasons <- fmt.Sprintf("idle timeout (%v)", timeout)
+				return
+			}
+			timer.Reset(timeout - idle)
+		}
 	}
 }
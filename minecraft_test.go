@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestForwardIdleTimeout(t *testing.T) {
+	player, downstream := tcpPair(t)
+	upConn, backend := tcpPair(t)
+	defer player.Close()
+	defer backend.Close()
+	reasons := make(chan string, 1)
+	go func() {
+		reasons <- forward(downstream, upConn, 200*time.Millisecond)
+	}()
+	// traffic keeps the connection alive past the timeout
+	for i := 0; i < 3; i++ {
+		_, _ = player.Write([]byte("ping"))
+		time.Sleep(100 * time.Millisecond)
+	}
+	select {
+	case reason := <-reasons:
+		t.Fatalf("Expected an active connection to stay open, closed with %s [FAILED]", reason)
+	default:
+	}
+	select {
+	case reason := <-reasons:
+		if !strings.HasPrefix(reason, "idle timeout") {
+			t.Errorf("Expected an idle timeout, got %s [FAILED]", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the idle connection to be closed [FAILED]")
+	}
+}
+
+func TestForwardHalfClose(t *testing.T) {
+	player, downstream := tcpPair(t)
+	upConn, backend := tcpPair(t)
+	reasons := make(chan string, 1)
+	go func() {
+		reasons <- forward(downstream, upConn, 0)
+	}()
+	_, _ = player.Write([]byte("request"))
+	_ = player.(*net.TCPConn).CloseWrite()
+	request, err := io.ReadAll(backend)
+	if err != nil || string(request) != "request" {
+		t.Fatalf("Expected the backend to read the request, got %q (%v) [FAILED]", request, err)
+	}
+	// the player is done sending, but the response must still arrive
+	_, _ = backend.Write([]byte("response"))
+	_ = backend.Close()
+	_ = player.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, err := io.ReadAll(player)
+	if err != nil || string(response) != "response" {
+		t.Errorf("Expected the player to read the response, got %q (%v) [FAILED]", response, err)
+	}
+	select {
+	case reason := <-reasons:
+		if reason != "client closed" {
+			t.Errorf("Expected the client to be reported as closed first, got %s [FAILED]", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected forward to return once both sides are done [FAILED]")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"time"
+)
+
+// outputOptions is embedded in the options of every session command so they
+// all accept --json and --format.
+type outputOptions struct {
+	JSON   bool   `long:"json" description:"Print the result as JSON"`
+	Format string `long:"format" description:"Output format" choice:"text" choice:"json" default:"text"`
+}
+
+type outputFormatter interface {
+	isJSON() bool
+}
+
+func (o *outputOptions) isJSON() bool {
+	return o.JSON || o.Format == "json"
+}
+
+// wantsJSON looks for --json or --format json in raw arguments, so errors
+// raised before or while parsing them are reported in the right format.
+func wantsJSON(args []string) bool {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return false
+		case arg == "--json", arg == "--format=json":
+			return true
+		case arg == "--format" && i+1 < len(args):
+			return args[i+1] == "json"
+		}
+	}
+	return false
+}
+
+func writeJSON(out io.Writer, value any) error {
+	return json.NewEncoder(out).Encode(value)
+}
+
+// The types below are the JSON output of the session commands, their field
+// names are part of the interface and must not change.
+
+type errorOutput struct {
+	Error    string `json:"error"`
+	ExitCode uint32 `json:"exit_code"`
+}
+
+type BindingStatus struct {
+	Domain        string       `json:"domain"`
+	Connections   int          `json:"connections"`
+	Traffic       TrafficStats `json:"traffic"`
+	RTTMillis     *float64     `json:"rtt_ms"`
+	ProxyProtocol bool         `json:"proxy_protocol"`
+	IdleTimeout   float64      `json:"idle_timeout_seconds"`
+	Draining      bool         `json:"draining"`
+}
+
+func NewBindingStatus(upstream McUpstream) BindingStatus {
+	return BindingStatus{
+		Domain:        upstream.Domain(),
+		Connections:   upstream.GetConnections(),
+		Traffic:       upstream.Traffic(),
+		RTTMillis:     roundTripMillis(upstream.SSHConn()),
+		ProxyProtocol: upstream.UseProxyProtocol(),
+		IdleTimeout:   upstream.IdleTimeout().Seconds(),
+		Draining:      upstream.Draining(),
+	}
+}
+
+type bindingsOutput struct {
+	Bindings []BindingStatus `json:"bindings"`
+}
+
+type listOutput struct {
+	Bindings []BindingStatus `json:"bindings"`
+	Usage    usageOutput     `json:"usage"`
+}
+
+type usageOutput struct {
+	User  string `json:"user"`
+	Month string `json:"month"`
+	TrafficStats
+}
+
+type drainOutput struct {
+	Domain    string `json:"domain"`
+	Remaining int    `json:"remaining"`
+	TimedOut  bool   `json:"timed_out"`
+}
+
+type infoOutput struct {
+	ConnectionInfo
+	RTTMillis *float64 `json:"rtt_ms"`
+}
+
+type commandOutput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type helpOutput struct {
+	Commands []commandOutput `json:"commands"`
+}
+
+func roundTripMillis(conn *ssh.ServerConn) *float64 {
+	rtt, ok := roundTrips.Get(conn)
+	if !ok {
+		return nil
+	}
+	millis := float64(rtt) / float64(time.Millisecond)
+	return &millis
+}
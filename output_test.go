@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWantsJSON(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"list"}, false},
+		{[]string{"list", "--json"}, true},
+		{[]string{"list", "--format=json"}, true},
+		{[]string{"list", "--format", "json"}, true},
+		{[]string{"list", "--format", "text"}, false},
+		{[]string{"list", "--format"}, false},
+		{[]string{"idle", "--", "--json"}, false},
+	}
+	for _, c := range cases {
+		if wantsJSON(c.args) != c.expected {
+			t.Errorf("Expected wantsJSON(%q) to be %t [FAILED]", c.args, c.expected)
+		}
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	bindings = NewBindingManager()
+	conn := newTestServerConn("alice", "*.example.com")
+	if err := bindings.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindings.AddBinding(conn, "a.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range []string{"list --json", "list -a --format json"} {
+		s, channel := newTestSession(conn, "")
+		if !s.Exec(command) {
+			t.Fatalf("Expected %q to succeed, got %q [FAILED]", command, channel.Output())
+		}
+		var output listOutput
+		if err := json.Unmarshal([]byte(channel.Output()), &output); err != nil {
+			t.Fatalf("Expected %q to print JSON, got %q [FAILED]", command, channel.Output())
+		}
+		if len(output.Bindings) != 1 || output.Bindings[0].Domain != "a.example.com" || output.Usage.User != "alice" {
+			t.Errorf("Expected the binding and usage of alice, got %+v [FAILED]", output)
+		}
+	}
+
+	s, channel := newTestSession(conn, "")
+	if s.Exec("idle --json -t 10 b.example.com") {
+		t.Fatalf("Expected an unknown binding to fail [FAILED]")
+	}
+	var failure errorOutput
+	if err := json.Unmarshal([]byte(channel.Output()), &failure); err != nil || failure.Error == "" || failure.ExitCode != 1 {
+		t.Errorf("Expected the error as JSON, got %q [FAILED]", channel.Output())
+	}
+	if status := <-channel.exitStatus; status.ExitCode != 1 {
+		t.Errorf("Expected exit status 1, got %d [FAILED]", status.ExitCode)
+	}
+}
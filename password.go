@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/Potterli20/go-flags-fork"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+	"os"
+	"strings"
+)
+
+type hashPasswordOptions struct {
+	Bcrypt  bool   `short:"b" long:"bcrypt" description:"Use bcrypt instead of argon2id"`
+	Cost    int    `short:"c" long:"cost" description:"bcrypt cost" default:"10"`
+	Time    uint32 `short:"t" long:"time" description:"argon2id iterations" default:"3"`
+	Memory  uint32 `short:"m" long:"memory" description:"argon2id memory in KiB" default:"65536"`
+	Threads uint8  `short:"p" long:"threads" description:"argon2id parallelism" default:"4"`
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Bounds of the argon2id parameters, both when hashing and for stored hashes,
+// so a typo in a user file cannot crash or stall logins.
+const (
+	argon2idMaxTime   = 100
+	argon2idMaxMemory = 1 << 20 // KiB
+)
+
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// isPasswordHash reports whether the stored password is a hash recognized by
+// checkPassword rather than plaintext.
+func isPasswordHash(stored string) bool {
+	if strings.HasPrefix(stored, argon2idPrefix) {
+		return true
+	}
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(stored, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword compares a password against a bcrypt or argon2id hash, or
+// against plaintext for configs that have not been migrated yet.
+func checkPassword(stored string, password string) bool {
+	if strings.HasPrefix(stored, argon2idPrefix) {
+		return checkArgon2id(stored, password)
+	}
+	if isPasswordHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	if len(stored) != len(password) {
+		subtle.ConstantTimeCompare([]byte(password), []byte(password))
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+func checkArgon2id(stored string, password string) bool {
+	// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil || validateArgon2id(time, memory, threads) != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(hash) == 0 {
+		return false
+	}
+	computed := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(hash)))
+	return subtle.ConstantTimeCompare(hash, computed) == 1
+}
+
+// validateArgon2id rejects parameters argon2.IDKey panics on or that would
+// take unreasonable time or memory.
+func validateArgon2id(time uint32, memory uint32, threads uint8) error {
+	if time < 1 || time > argon2idMaxTime {
+		return fmt.Errorf("argon2id time must be between 1 and %d", argon2idMaxTime)
+	}
+	if memory < 8*uint32(threads) || memory > argon2idMaxMemory {
+		return fmt.Errorf("argon2id memory must be between 8 KiB per thread and %d KiB", argon2idMaxMemory)
+	}
+	if threads < 1 {
+		return fmt.Errorf("argon2id threads must be at least 1")
+	}
+	return nil
+}
+
+func hashArgon2id(password string, time uint32, memory uint32, threads uint8) (string, error) {
+	err := validateArgon2id(time, memory, threads)
+	if err != nil {
+		return "", err
+	}
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(password), salt, time, memory, threads, 32)
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, memory, time, threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+func runHashPassword(args []string) {
+	var opts hashPasswordOptions
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.Name = "mcrouter hash-password"
+	parser.LongDescription = "Read a password from stdin and print a hash for the password field of a user file"
+	_, err := parser.ParseArgs(args)
+	if flags.WroteHelp(err) {
+		return
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var hash string
+	if opts.Bcrypt {
+		var binary []byte
+		binary, err = bcrypt.GenerateFromPassword([]byte(password), opts.Cost)
+		hash = string(binary)
+	} else {
+		hash, err = hashArgon2id(password, opts.Time, opts.Memory, opts.Threads)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(hash)
+}
+
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" && err != nil {
+			return "", fmt.Errorf("failed to read password: %v", err)
+		}
+		return line, nil
+	}
+	_, _ = fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	_, _ = fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(password) != string(confirm) {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return string(password), nil
+}
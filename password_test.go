@@ -0,0 +1,52 @@
+package main
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	argon2id, err := hashArgon2id("secret", 1, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := map[string]string{
+		"plaintext": "secret",
+		"argon2id":  argon2id,
+		"bcrypt":    string(bcryptHash),
+	}
+	for name, hash := range stored {
+		if !checkPassword(hash, "secret") {
+			t.Errorf("Expected %s to accept the right password [FAILED]", name)
+		}
+		if checkPassword(hash, "wrong") {
+			t.Errorf("Expected %s to reject a wrong password [FAILED]", name)
+		}
+		if isPasswordHash(hash) != (name != "plaintext") {
+			t.Errorf("Expected %s to be detected as hash: %t [FAILED]", name, name != "plaintext")
+		}
+	}
+}
+
+func TestArgon2idParameters(t *testing.T) {
+	for _, params := range [][3]uint32{{0, 1024, 1}, {1, 1024, 0}, {1, 4, 1}, {1, argon2idMaxMemory + 1, 1}, {argon2idMaxTime + 1, 1024, 1}} {
+		if _, err := hashArgon2id("secret", params[0], params[1], uint8(params[2])); err == nil {
+			t.Errorf("Expected t=%d,m=%d,p=%d to be rejected [FAILED]", params[0], params[1], params[2])
+		}
+	}
+	hash, err := hashArgon2id("secret", 1, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, params := range []string{"m=1024,t=1,p=0", "m=1024,t=0,p=1", "m=0,t=1,p=1"} {
+		stored := strings.Replace(hash, "m=1024,t=1,p=1", params, 1)
+		if checkPassword(stored, "secret") {
+			t.Errorf("Expected a stored hash with %s to be rejected [FAILED]", params)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func newTestKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func krlString(data []byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	return append(out, data...)
+}
+
+func krlSection(sectionType byte, data []byte) []byte {
+	return append([]byte{sectionType}, krlString(data)...)
+}
+
+func TestKRL(t *testing.T) {
+	ca := newTestKey(t)
+	otherCA := newTestKey(t)
+	revokedKey := newTestKey(t)
+	validKey := newTestKey(t)
+
+	var serials []byte
+	serials = binary.BigEndian.AppendUint64(serials, 5)
+	serials = binary.BigEndian.AppendUint64(serials, 7)
+	var serialRange []byte
+	serialRange = binary.BigEndian.AppendUint64(serialRange, 100)
+	serialRange = binary.BigEndian.AppendUint64(serialRange, 200)
+
+	certs := krlString(ca.Marshal())
+	certs = append(certs, krlString(nil)...)
+	certs = append(certs, krlSection(krlSectionCertSerialList, serials)...)
+	certs = append(certs, krlSection(krlSectionCertSerialRange, serialRange)...)
+	certs = append(certs, krlSection(krlSectionCertKeyID, krlString([]byte("leaked")))...)
+
+	krl := []byte(krlMagic)
+	krl = binary.BigEndian.AppendUint32(krl, 1)
+	krl = binary.BigEndian.AppendUint64(krl, 1)
+	krl = binary.BigEndian.AppendUint64(krl, 0)
+	krl = binary.BigEndian.AppendUint64(krl, 0)
+	krl = append(krl, krlString(nil)...)
+	krl = append(krl, krlString([]byte("test"))...)
+	krl = append(krl, krlSection(krlSectionCertificates, certs)...)
+	krl = append(krl, krlSection(krlSectionExplicitKey, krlString(revokedKey.Marshal()))...)
+
+	revocations, err := ParseRevocations(krl)
+	if err != nil {
+		t.Fatalf("Failed to parse KRL: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		key     ssh.PublicKey
+		revoked bool
+	}{
+		{"explicit key", revokedKey, true},
+		{"valid key", validKey, false},
+		{"listed serial", &ssh.Certificate{Key: validKey, SignatureKey: ca, Serial: 7}, true},
+		{"serial in range", &ssh.Certificate{Key: validKey, SignatureKey: ca, Serial: 150}, true},
+		{"unlisted serial", &ssh.Certificate{Key: validKey, SignatureKey: ca, Serial: 6}, false},
+		{"key id", &ssh.Certificate{Key: validKey, SignatureKey: ca, Serial: 1, KeyId: "leaked"}, true},
+		{"other authority", &ssh.Certificate{Key: validKey, SignatureKey: otherCA, Serial: 7}, false},
+		{"revoked signed key", &ssh.Certificate{Key: revokedKey, SignatureKey: ca, Serial: 1}, true},
+	}
+	for _, c := range cases {
+		if revoked := revocations.IsRevoked(c.key); revoked != c.revoked {
+			t.Errorf("Expected %s revoked to be %t, got %t [FAILED]", c.name, c.revoked, revoked)
+		}
+	}
+}
+
+func TestSerialList(t *testing.T) {
+	ca := newTestKey(t)
+	key := newTestKey(t)
+	revocations, err := ParseRevocations([]byte("# revoked serials\n3\n10-20 # contractor\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse serial list: %v", err)
+	}
+	cases := map[uint64]bool{1: false, 3: true, 10: true, 15: true, 20: true, 21: false}
+	for serial, expected := range cases {
+		cert := &ssh.Certificate{Key: key, SignatureKey: ca, Serial: serial}
+		if revoked := revocations.IsRevoked(cert); revoked != expected {
+			t.Errorf("Expected serial %d revoked to be %t, got %t [FAILED]", serial, expected, revoked)
+		}
+	}
+	if _, err = ParseRevocations([]byte("20-10\n")); err == nil {
+		t.Errorf("Expected inverted range to be rejected [FAILED]")
+	}
+}
@@ -2,28 +2,62 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/Potterli20/go-flags-fork"
 	"github.com/google/shlex"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 	"io"
+	"log"
+	"math"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
 )
 
 type proxyProtoCommandOptions struct {
-	Enable  []string `short:"E" name:"enable" description:"Bindings to enable Proxy Protocol for"`
-	Disable []string `short:"D" name:"disable" description:"Bindings to disable Proxy Protocol for"`
+	outputOptions
+	Enable  []string `short:"E" long:"enable" description:"Bindings to enable Proxy Protocol for"`
+	Disable []string `short:"D" long:"disable" description:"Bindings to disable Proxy Protocol for"`
+}
+
+type drainCommandOptions struct {
+	outputOptions
+	Timeout uint32 `short:"t" long:"timeout" description:"Seconds to wait before removing the binding anyway" default:"300"`
+}
+
+type idleCommandOptions struct {
+	outputOptions
+	Timeout *uint32 `short:"t" long:"timeout" description:"Idle timeout in seconds, 0 to disable"`
+	Reset   bool    `short:"r" long:"reset" description:"Use the router default idle timeout"`
 }
 
 type listCommandOptions struct {
-	All bool `short:"a" name:"all" description:"Print all details"`
+	outputOptions
+	All bool `short:"a" long:"all" description:"Print all details"`
 }
 
-type emptyCommandOptions struct{}
+type connectionsCommandOptions struct {
+	outputOptions
+}
+
+type killCommandOptions struct {
+	outputOptions
+	IP string `long:"ip" description:"Kill every player connection from this IP address or CIDR range"`
+}
+
+type logsCommandOptions struct {
+	outputOptions
+	Follow bool `short:"f" long:"follow" description:"Keep printing new events"`
+	Lines  int  `short:"n" long:"lines" description:"Number of recent events to print" default:"50"`
+}
+
+type emptyCommandOptions struct {
+	outputOptions
+}
 
 type exitStatus struct {
 	ExitCode uint32
@@ -40,12 +74,16 @@ type sessionIO struct {
 }
 
 type session struct {
-	conn     *ssh.ServerConn
-	channel  ssh.Channel
-	signals  <-chan string
-	io       SessionIO
-	isPty    bool
-	needStop bool
+	conn      *ssh.ServerConn
+	channel   ssh.Channel
+	signals   <-chan string
+	io        SessionIO
+	input     *ptyInput
+	isPty     bool
+	json      bool
+	started   bool
+	scripting bool
+	needStop  bool
 }
 
 type Session interface {
@@ -54,7 +92,7 @@ type Session interface {
 	NeedStop() bool
 }
 
-func NewSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request, pty *term.Terminal) Session {
+func NewSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request, pty *term.Terminal, input *ptyInput) Session {
 	signals := make(chan string)
 	go func() {
 		for req := range requests {
@@ -100,20 +138,80 @@ func NewSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.
 		ses.io = &sessionIO{channel, bufio.NewReader(channel)}
 	} else {
 		ses.io = pty
+		ses.input = input
 		ses.isPty = true
+		pty.AutoCompleteCallback = ses.complete
 	}
 	return ses
 }
 
-func (s *session) Exec(command string) bool {
+func (s *session) Exec(line string) bool {
+	status, ok := s.execLine(line)
+	_, _ = s.channel.SendRequest("exit-status", false, ssh.Marshal(status))
+	return ok
+}
+
+// execLine runs the commands of a line separated by ; or &&, a command after
+// && only runs when the one before it succeeded. The status is the one of the
+// last command that ran.
+func (s *session) execLine(line string) (exitStatus, bool) {
+	s.json = wantsJSON(strings.Fields(line))
+	steps, err := splitCommands(line)
+	if err != nil {
+		return s.reportError(err)
+	}
+	status, ok := exitStatus{}, true
+	for _, step := range steps {
+		if step.afterSuccess && !ok {
+			continue
+		}
+		status, ok = s.run(step.command)
+		if s.needStop {
+			break
+		}
+	}
+	return status, ok
+}
+
+func (s *session) run(command string) (exitStatus, bool) {
 	args, err := shlex.Split(command)
-	var status exitStatus
+	s.json = wantsJSON(args)
 	if err == nil && len(args) > 0 {
 		switch args[0] {
 		case "proxy", "p":
 			err = s.handleProxyCommand(args)
+		case "drain":
+			err = s.handleDrainCommand(args)
+		case "idle", "i":
+			err = s.handleIdleCommand(args)
 		case "list", "ls":
 			err = s.handleListCommand(args)
+		case "connections", "conns":
+			err = s.handleConnectionsCommand(args)
+		case "kill":
+			err = s.handleKillCommand(args)
+		case "ban":
+			err = s.handleBanCommand(args)
+		case "unban":
+			err = s.handleUnbanCommand(args)
+		case "bans":
+			err = s.handleBansCommand(args)
+		case "logs":
+			err = s.handleLogsCommand(args)
+		case "top":
+			err = s.handleTopCommand(args)
+		case "alias":
+			err = s.handleAliasCommand(args)
+		case "motd":
+			err = s.handleMOTDCommand(args)
+		case "favicon":
+			err = s.handleFaviconCommand(args)
+		case "script":
+			return s.handleScriptCommand(args)
+		case "whoami", "info":
+			err = s.handleInfoCommand(args)
+		case "admin":
+			err = s.handleAdminCommand(args)
 		case "help", "h", "?":
 			err = s.handleHelpCommand(args)
 		case "exit", "quit", "q":
@@ -129,25 +227,32 @@ func (s *session) Exec(command string) bool {
 		}
 	}
 	if err != nil {
-		status.ExitCode = 1
-		var out io.Writer = s.channel.Stderr()
-		if flags.WroteHelp(err) {
-			out = s.io
-			status.ExitCode = 0
-		}
-		if s.isPty {
-			out = s.io
-		}
+		return s.reportError(err)
+	}
+	return exitStatus{}, true
+}
+
+func (s *session) reportError(err error) (exitStatus, bool) {
+	var status exitStatus
+	status.ExitCode = 1
+	var out io.Writer = s.channel.Stderr()
+	if flags.WroteHelp(err) {
+		out = s.io
+		status.ExitCode = 0
+	}
+	if s.isPty {
+		out = s.io
+	}
+	if s.json && !flags.WroteHelp(err) {
+		_ = writeJSON(out, errorOutput{Error: err.Error(), ExitCode: status.ExitCode})
+	} else {
 		_, _ = fmt.Fprintln(out, err)
-		_, _ = s.channel.SendRequest("exit-status", false, ssh.Marshal(status))
-		return false
 	}
-	status.ExitCode = 0
-	_, _ = s.channel.SendRequest("exit-status", false, ssh.Marshal(status))
-	return true
+	return status, false
 }
 
 func (s *session) Start() {
+	s.started = true
 	defer func() {
 		_ = s.channel.Close()
 	}()
@@ -194,7 +299,11 @@ func (s *session) parseArgs(args []string, out any, help string) ([]string, erro
 	parser := flags.NewParser(out, flags.Default^flags.PrintErrors)
 	parser.Name = args[0]
 	parser.LongDescription = help
-	return parser.ParseArgs(args)
+	rest, err := parser.ParseArgs(args)
+	if formatter, ok := out.(outputFormatter); ok && err == nil {
+		s.json = formatter.isJSON()
+	}
+	return rest, err
 }
 
 func (s *session) handleProxyCommand(args []string) error {
@@ -203,22 +312,155 @@ func (s *session) handleProxyCommand(args []string) error {
 	if err != nil {
 		return err
 	}
-	if len(opts.Enable) == 0 && len(opts.Disable) == 0 {
+	if len(opts.Enable) == 0 && len(opts.Disable) == 0 && !s.json {
 		_, _ = fmt.Fprintln(s.io, "No bindings specified")
 	}
+	output := bindingsOutput{Bindings: []BindingStatus{}}
 	for _, binding := range opts.Enable {
 		err = bindings.SetProxyProtocol(s.conn, binding, true)
 		if err != nil {
 			return err
 		}
-		_, _ = fmt.Fprintln(s.io, "Enabled proxy protocol for", binding)
+		if !s.json {
+			_, _ = fmt.Fprintln(s.io, "Enabled proxy protocol for", binding)
+		}
+		upstream, _ := bindings.Lookup(s.conn, binding)
+		output.Bindings = append(output.Bindings, NewBindingStatus(upstream))
 	}
 	for _, binding := range opts.Disable {
 		err = bindings.SetProxyProtocol(s.conn, binding, false)
 		if err != nil {
 			return err
 		}
-		_, _ = fmt.Fprintln(s.io, "Enabled proxy protocol for", binding)
+		if !s.json {
+			_, _ = fmt.Fprintln(s.io, "Disabled proxy protocol for", binding)
+		}
+		upstream, _ := bindings.Lookup(s.conn, binding)
+		output.Bindings = append(output.Bindings, NewBindingStatus(upstream))
+	}
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	return nil
+}
+
+func (s *session) handleDrainCommand(args []string) error {
+	var opts drainCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Stop routing new players to a binding and remove it once empty")
+	if err != nil {
+		return err
+	}
+	if len(rest) != 2 {
+		return fmt.Errorf("exactly one binding must be specified")
+	}
+	binding := rest[1]
+	upstream, err := bindings.Lookup(s.conn, binding)
+	if err != nil {
+		return err
+	}
+	upstream.SetDraining(true)
+	// Ctrl-C in a terminal, a signal or closing the session cancels draining
+	var keys <-chan []byte
+	if s.input != nil {
+		captured, release := s.input.Capture()
+		defer release()
+		keys = captured
+	}
+	deadline := time.Now().Add(time.Duration(opts.Timeout) * time.Second)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	output := drainOutput{Domain: binding, Remaining: -1}
+	for output.Remaining != 0 && !output.TimedOut {
+		select {
+		case <-ticker.C:
+		case pressed := <-keys:
+			if strings.Contains(string(pressed), "\x03") {
+				return s.cancelDrain(upstream)
+			}
+			continue
+		case signal, ok := <-s.signals:
+			if !ok {
+				s.needStop = true
+				return s.cancelDrain(upstream)
+			}
+			switch signal {
+			case "INT", "TERM", "KILL":
+				return s.cancelDrain(upstream)
+			}
+			continue
+		}
+		count := upstream.GetConnections()
+		if count != output.Remaining {
+			output.Remaining = count
+			if !s.json {
+				_, _ = fmt.Fprintf(s.io, "Draining %s, %d connections remaining\n", binding, output.Remaining)
+			}
+		}
+		output.TimedOut = output.Remaining > 0 && time.Now().After(deadline)
+	}
+	if current, err := bindings.Lookup(s.conn, binding); err != nil || current != upstream {
+		return fmt.Errorf("binding %s was removed while draining", binding)
+	}
+	bindings.RemoveBinding(binding)
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	if output.TimedOut {
+		_, _ = fmt.Fprintf(s.io, "Timed out, removed binding %s and dropped %d connections\n", binding, output.Remaining)
+	} else {
+		_, _ = fmt.Fprintln(s.io, "Removed binding", binding)
+	}
+	return nil
+}
+
+// cancelDrain routes new players to the binding again, unless it was removed
+// in the meantime.
+func (s *session) cancelDrain(upstream McUpstream) error {
+	if current, err := bindings.Lookup(s.conn, upstream.Domain()); err == nil && current == upstream {
+		upstream.SetDraining(false)
+	}
+	return fmt.Errorf("draining %s cancelled, the binding is kept", upstream.Domain())
+}
+
+func (s *session) handleIdleCommand(args []string) error {
+	var opts idleCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Config idle timeout for bindings")
+	if err != nil {
+		return err
+	}
+	rest = rest[1:]
+	if len(rest) == 0 && !s.json {
+		_, _ = fmt.Fprintln(s.io, "No bindings specified")
+	}
+	if opts.Timeout != nil && opts.Reset {
+		return fmt.Errorf("--timeout and --reset cannot be used together")
+	}
+	output := bindingsOutput{Bindings: []BindingStatus{}}
+	for _, binding := range rest {
+		if opts.Timeout != nil {
+			err = bindings.SetIdleTimeout(s.conn, binding, time.Duration(*opts.Timeout)*time.Second)
+		} else if opts.Reset {
+			err = bindings.SetIdleTimeout(s.conn, binding, -1)
+		}
+		if err != nil {
+			return err
+		}
+		upstream, err := bindings.Lookup(s.conn, binding)
+		if err != nil {
+			return err
+		}
+		output.Bindings = append(output.Bindings, NewBindingStatus(upstream))
+		if s.json {
+			continue
+		}
+		if timeout := upstream.IdleTimeout(); timeout > 0 {
+			_, _ = fmt.Fprintf(s.io, "Idle timeout for %s is %v\n", binding, timeout)
+		} else {
+			_, _ = fmt.Fprintf(s.io, "Idle timeout for %s is disabled\n", binding)
+		}
+	}
+	if s.json {
+		return writeJSON(s.io, output)
 	}
 	return nil
 }
@@ -229,17 +471,45 @@ func (s *session) handleListCommand(args []string) error {
 	if err != nil {
 		return err
 	}
+	if s.json {
+		// JSON output always carries every detail, -a only affects the table
+		output := listOutput{Bindings: []BindingStatus{}}
+		_ = bindings.EachBinding(s.conn, func(upstream McUpstream) error {
+			output.Bindings = append(output.Bindings, NewBindingStatus(upstream))
+			return nil
+		})
+		sort.Slice(output.Bindings, func(i, j int) bool {
+			return output.Bindings[i].Domain < output.Bindings[j].Domain
+		})
+		month := currentMonth()
+		output.Usage = usageOutput{
+			User:         s.conn.User(),
+			Month:        month,
+			TrafficStats: usage.Stats(month, s.conn.User()),
+		}
+		return writeJSON(s.io, output)
+	}
 	if opts.All {
 		writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
-		_, _ = writer.Write([]byte("DOMAIN\tCONNECTIONS\tPROXY PROTOCOL\n"))
+		_, _ = writer.Write([]byte("DOMAIN\tCONNECTIONS\tTOTAL\tIN\tOUT\tRTT\tPROXY PROTOCOL\n"))
 		_ = bindings.EachBinding(s.conn, func(upstream McUpstream) error {
+			traffic := upstream.Traffic()
 			_, _ = fmt.Fprintf(
-				writer, "%s\t%d\t%t\n",
-				upstream.Domain(), upstream.GetConnections(), upstream.UseProxyProtocol(),
+				writer, "%s\t%d\t%d\t%s\t%s\t%s\t%t\n",
+				upstream.Domain(), upstream.GetConnections(), traffic.Connections,
+				formatBytes(traffic.BytesIn), formatBytes(traffic.BytesOut),
+				formatRoundTrip(upstream.SSHConn()), upstream.UseProxyProtocol(),
 			)
 			return nil
 		})
 		_ = writer.Flush()
+		month := currentMonth()
+		traffic := usage.Stats(month, s.conn.User())
+		_, _ = fmt.Fprintf(
+			s.io, "Usage of %s in %s: %d connections, %s in, %s out\n",
+			s.conn.User(), month, traffic.Connections,
+			formatBytes(traffic.BytesIn), formatBytes(traffic.BytesOut),
+		)
 	} else {
 		var domains []string
 		_ = bindings.EachBinding(s.conn, func(upstream McUpstream) error {
@@ -252,6 +522,355 @@ func (s *session) handleListCommand(args []string) error {
 	return nil
 }
 
+func (s *session) handleConnectionsCommand(args []string) error {
+	var opts connectionsCommandOptions
+	rest, err := s.parseArgs(args, &opts, "List player connections of all or the given bindings")
+	if err != nil {
+		return err
+	}
+	upstreams, err := s.userBindings(rest[1:])
+	if err != nil {
+		return err
+	}
+	var output struct {
+		Connections []PlayerConnection `json:"connections"`
+	}
+	output.Connections = []PlayerConnection{}
+	for _, upstream := range upstreams {
+		output.Connections = append(output.Connections, upstream.Connections()...)
+	}
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	_, _ = writer.Write([]byte("ID\tDOMAIN\tSOURCE\tPLAYER\tSINCE\tIN\tOUT\n"))
+	for _, conn := range output.Connections {
+		player := conn.Player
+		if player == "" {
+			player = "-"
+		}
+		_, _ = fmt.Fprintf(
+			writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			conn.ID, conn.Domain, conn.RemoteAddr, player, conn.Since.Format(time.DateTime),
+			formatBytes(conn.BytesIn), formatBytes(conn.BytesOut),
+		)
+	}
+	return writer.Flush()
+}
+
+func (s *session) handleKillCommand(args []string) error {
+	var opts killCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Close player connections by ID, or by source with --ip")
+	if err != nil {
+		return err
+	}
+	rest = rest[1:]
+	upstreams, err := s.userBindings(nil)
+	if err != nil {
+		return err
+	}
+	var output struct {
+		Killed int `json:"killed"`
+	}
+	if opts.IP != "" {
+		if len(rest) > 0 {
+			return fmt.Errorf("connection IDs cannot be used with --ip")
+		}
+		network, err := parseNetwork(opts.IP)
+		if err != nil {
+			return err
+		}
+		for _, upstream := range upstreams {
+			output.Killed += upstream.KillIP(network)
+		}
+	} else {
+		if len(rest) == 0 {
+			return fmt.Errorf("no connection IDs specified")
+		}
+	ids:
+		for _, arg := range rest {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid connection ID: %s", arg)
+			}
+			for _, upstream := range upstreams {
+				if upstream.Kill(id) {
+					output.Killed++
+					continue ids
+				}
+			}
+			return fmt.Errorf("connection %d does not exist", id)
+		}
+	}
+	log.Printf("[SSH] %s killed %d player connections", s.conn.User(), output.Killed)
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	_, _ = fmt.Fprintf(s.io, "Killed %d connections\n", output.Killed)
+	return nil
+}
+
+func (s *session) handleLogsCommand(args []string) error {
+	var opts logsCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Show recent events of all or the given bindings, -f to follow")
+	if err != nil {
+		return err
+	}
+	if opts.Lines < 0 {
+		return fmt.Errorf("the number of lines cannot be negative")
+	}
+	domains := NewSet[string]()
+	for _, domain := range rest[1:] {
+		domains.Add(domain)
+	}
+	matches := func(event Event) bool {
+		return domains.Len() == 0 || domains.Contains(event.Domain)
+	}
+	printEvent := func(event Event) {
+		if s.json {
+			_ = writeJSON(s.io, event)
+		} else {
+			_, _ = fmt.Fprintln(s.io, event)
+		}
+	}
+	var (
+		live        <-chan Event
+		unsubscribe = func() {}
+	)
+	if opts.Follow {
+		live, unsubscribe = events.Subscribe(s.conn.User())
+	}
+	defer unsubscribe()
+	var last time.Time
+	// Recent has no domain filter, ask for everything and keep the tail
+	var history []Event
+	for _, event := range events.Recent(s.conn.User(), math.MaxInt) {
+		if matches(event) {
+			history = append(history, event)
+		}
+	}
+	if len(history) > opts.Lines {
+		history = history[len(history)-opts.Lines:]
+	}
+	for _, event := range history {
+		printEvent(event)
+		last = event.Time
+	}
+	if !opts.Follow {
+		return nil
+	}
+	var keys <-chan []byte
+	if s.isPty {
+		var release func()
+		keys, release = s.input.Capture()
+		defer release()
+	}
+	for {
+		select {
+		case event := <-live:
+			if matches(event) && event.Time.After(last) {
+				printEvent(event)
+			}
+		case pressed := <-keys:
+			// q, Q or Ctrl-C
+			if strings.ContainsAny(string(pressed), "qQ\x03") {
+				return nil
+			}
+		case signal, ok := <-s.signals:
+			if !ok {
+				s.needStop = true
+				return nil
+			}
+			switch signal {
+			case "INT", "TERM", "KILL":
+				return nil
+			}
+		}
+	}
+}
+
+type banOutput struct {
+	Domain string    `json:"domain"`
+	Target string    `json:"target"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+func (s *session) handleBanCommand(args []string) error {
+	var options emptyCommandOptions
+	rest, err := s.parseArgs(args, &options, "Ban an IP address or CIDR range from a binding: ban <domain> <ip|cidr> [duration] [reason]")
+	if err != nil {
+		return err
+	}
+	rest = rest[1:]
+	if len(rest) < 2 {
+		return fmt.Errorf("a binding and an IP address or CIDR range must be specified")
+	}
+	upstreams, err := s.userBindings(rest[:1])
+	if err != nil {
+		return err
+	}
+	target, err := normalizeBanTarget(rest[1])
+	if err != nil {
+		return err
+	}
+	duration := time.Duration(opts.BanDuration) * time.Hour
+	rest = rest[2:]
+	if len(rest) > 0 {
+		if parsed, ok := parseBanDuration(rest[0]); ok {
+			duration = parsed
+			rest = rest[1:]
+		}
+	}
+	reason := strings.Join(rest, " ")
+	if reason == "" {
+		reason = "banned by owner"
+	}
+	list, _ := BindingBans(upstreams[0], true)
+	until := time.Now().Add(duration).Round(0)
+	_ = list.Add(target, until, reason)
+	log.Printf("[SSH] %s banned %s from %s until %v (%s)", s.conn.User(), target, upstreams[0].Domain(), until, reason)
+	if s.json {
+		return writeJSON(s.io, banOutput{Domain: upstreams[0].Domain(), Target: target, Until: until, Reason: reason})
+	}
+	_, _ = fmt.Fprintf(s.io, "Banned %s from %s until %s\n", target, upstreams[0].Domain(), until.Format(time.DateTime))
+	return nil
+}
+
+func (s *session) handleUnbanCommand(args []string) error {
+	var opts emptyCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Lift a ban from a binding: unban <domain> <ip|cidr>")
+	if err != nil {
+		return err
+	}
+	if len(rest) != 3 {
+		return fmt.Errorf("a binding and an IP address or CIDR range must be specified")
+	}
+	upstreams, err := s.userBindings(rest[1:2])
+	if err != nil {
+		return err
+	}
+	list, ok := BindingBans(upstreams[0], false)
+	if !ok || !list.Remove(rest[2]) {
+		return fmt.Errorf("%s is not banned from %s", rest[2], upstreams[0].Domain())
+	}
+	log.Printf("[SSH] %s unbanned %s from %s", s.conn.User(), rest[2], upstreams[0].Domain())
+	if s.json {
+		return writeJSON(s.io, struct {
+			Domain string `json:"domain"`
+			Target string `json:"target"`
+		}{upstreams[0].Domain(), rest[2]})
+	}
+	_, _ = fmt.Fprintf(s.io, "Unbanned %s from %s\n", rest[2], upstreams[0].Domain())
+	return nil
+}
+
+func (s *session) handleBansCommand(args []string) error {
+	var opts emptyCommandOptions
+	rest, err := s.parseArgs(args, &opts, "List the bans of all or the given bindings")
+	if err != nil {
+		return err
+	}
+	upstreams, err := s.userBindings(rest[1:])
+	if err != nil {
+		return err
+	}
+	var output struct {
+		Bans []banOutput `json:"bans"`
+	}
+	output.Bans = []banOutput{}
+	now := time.Now()
+	for _, upstream := range upstreams {
+		list, ok := BindingBans(upstream, false)
+		if !ok {
+			continue
+		}
+		_ = list.Each(func(target string, ban Ban) error {
+			if ban.Until.After(now) {
+				output.Bans = append(output.Bans, banOutput{Domain: upstream.Domain(), Target: target, Until: ban.Until, Reason: ban.Reason})
+			}
+			return nil
+		})
+	}
+	sort.Slice(output.Bans, func(i, j int) bool {
+		if output.Bans[i].Domain != output.Bans[j].Domain {
+			return output.Bans[i].Domain < output.Bans[j].Domain
+		}
+		return output.Bans[i].Target < output.Bans[j].Target
+	})
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	_, _ = writer.Write([]byte("DOMAIN\tTARGET\tUNTIL\tREASON\n"))
+	for _, ban := range output.Bans {
+		_, _ = fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", ban.Domain, ban.Target, ban.Until.Format(time.DateTime), ban.Reason)
+	}
+	return writer.Flush()
+}
+
+// parseBanDuration accepts Go durations like 30m or 12h, and days like 7d.
+func parseBanDuration(value string) (time.Duration, bool) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		count, err := strconv.ParseUint(days, 10, 32)
+		return time.Duration(count) * 24 * time.Hour, err == nil && count > 0
+	}
+	duration, err := time.ParseDuration(value)
+	return duration, err == nil && duration > 0
+}
+
+// userBindings returns the given bindings of the session user, on any of
+// their SSH connections, or all of them when none is given.
+func (s *session) userBindings(domains []string) ([]McUpstream, error) {
+	owned := make(map[string]McUpstream)
+	_ = bindings.EachUserBinding(s.conn.User(), func(upstream McUpstream) error {
+		owned[upstream.Domain()] = upstream
+		return nil
+	})
+	var upstreams []McUpstream
+	if len(domains) == 0 {
+		for _, upstream := range owned {
+			upstreams = append(upstreams, upstream)
+		}
+		sort.Slice(upstreams, func(i, j int) bool {
+			return upstreams[i].Domain() < upstreams[j].Domain()
+		})
+		return upstreams, nil
+	}
+	for _, domain := range domains {
+		upstream, ok := owned[domain]
+		if !ok {
+			return nil, fmt.Errorf("binding does not exist")
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams, nil
+}
+
+func (s *session) handleInfoCommand(args []string) error {
+	var opts emptyCommandOptions
+	_, err := s.parseArgs(args, &opts, "Show details of this SSH connection")
+	if err != nil {
+		return err
+	}
+	info, _ := connectionInfos.Get(s.conn)
+	if s.json {
+		return writeJSON(s.io, infoOutput{ConnectionInfo: info, RTTMillis: roundTripMillis(s.conn)})
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	_, _ = fmt.Fprintf(writer, "User:\t%s\n", info.User)
+	_, _ = fmt.Fprintf(writer, "Session:\t%s\n", info.SessionID)
+	_, _ = fmt.Fprintf(writer, "Client:\t%s\n", info.ClientVersion)
+	_, _ = fmt.Fprintf(writer, "Remote address:\t%s\n", info.RemoteAddr)
+	_, _ = fmt.Fprintf(writer, "Connected since:\t%s (%v)\n", info.ConnectedAt.Format(time.RFC3339), time.Since(info.ConnectedAt).Round(time.Second))
+	_, _ = fmt.Fprintf(writer, "Auth method:\t%s\n", info.AuthMethod)
+	if info.KeyFingerprint != "" {
+		_, _ = fmt.Fprintf(writer, "Key fingerprint:\t%s\n", info.KeyFingerprint)
+	}
+	_, _ = fmt.Fprintf(writer, "RTT:\t%s\n", formatRoundTrip(s.conn))
+	return writer.Flush()
+}
+
 func (s *session) handleExitCommand(args []string) error {
 	var opts emptyCommandOptions
 	_, err := s.parseArgs(args, &opts, "Exit")
@@ -262,19 +881,148 @@ func (s *session) handleExitCommand(args []string) error {
 	return nil
 }
 
+var sessionCommands = []commandOutput{
+	{"proxy", "Config proxy protocol for bindings"},
+	{"drain", "Stop routing new players to a binding and remove it once empty"},
+	{"idle", "Config idle timeout for bindings"},
+	{"list", "List bindings"},
+	{"top", "Show a live view of bindings and events"},
+	{"connections", "List player connections"},
+	{"kill", "Close player connections"},
+	{"logs", "Show the events of bindings"},
+	{"ban", "Ban an IP address or range from a binding"},
+	{"unban", "Lift a ban from a binding"},
+	{"bans", "List the bans of bindings"},
+	{"alias", "Route extra hostnames to a binding"},
+	{"motd", "Set the server list MOTD of a binding while it is offline"},
+	{"favicon", "Set the server list icon of a binding while it is offline"},
+	{"script", "Run commands from stdin, stopping at the first failure"},
+	{"whoami", "Show details of this SSH connection"},
+	{"admin", "Manage all users, bindings and global bans"},
+	{"exit", "Exit"},
+}
+
 func (s *session) handleHelpCommand(args []string) error {
 	var opts emptyCommandOptions
 	_, err := s.parseArgs(args, &opts, "Show help")
 	if err != nil {
 		return err
 	}
+	commands := []commandOutput{}
+	for _, command := range sessionCommands {
+		if command.Name != "admin" || isAdmin(s.conn) {
+			commands = append(commands, command)
+		}
+	}
+	if s.json {
+		return writeJSON(s.io, helpOutput{Commands: commands})
+	}
 	_, _ = fmt.Fprintln(s.io, "Commands:")
-	_, _ = fmt.Fprintln(s.io, "  proxy - Config proxy protocol for bindings")
-	_, _ = fmt.Fprintln(s.io, "  list - List bindings")
-	_, _ = fmt.Fprintln(s.io, "  exit - Exit")
+	for _, command := range commands {
+		_, _ = fmt.Fprintf(s.io, "  %s - %s\n", command.Name, command.Description)
+	}
 	return nil
 }
 
+type commandStep struct {
+	command      string
+	afterSuccess bool
+}
+
+// splitCommands splits a line at ; and && outside of quotes, like a shell
+// would, and leaves the quoting of every command to shlex.
+func splitCommands(line string) ([]commandStep, error) {
+	var (
+		steps   []commandStep
+		current strings.Builder
+		quote   rune
+		escaped bool
+		next    commandStep
+	)
+	finish := func(operator string) error {
+		next.command = strings.TrimSpace(current.String())
+		current.Reset()
+		if next.command == "" {
+			if next.afterSuccess || operator == "&&" {
+				return fmt.Errorf("syntax error near &&")
+			}
+		} else {
+			steps = append(steps, next)
+		}
+		next = commandStep{afterSuccess: operator == "&&"}
+		return nil
+	}
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case char == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case char == ';':
+			if err := finish(";"); err != nil {
+				return nil, err
+			}
+			continue
+		case char == '&' && i+1 < len(runes) && runes[i+1] == '&':
+			if err := finish("&&"); err != nil {
+				return nil, err
+			}
+			i++
+			continue
+		}
+		current.WriteRune(char)
+	}
+	if err := finish(""); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// handleScriptCommand runs the script read from stdin, the status is the one
+// of the command that failed, which already reported its error.
+func (s *session) handleScriptCommand(args []string) (exitStatus, bool) {
+	var opts emptyCommandOptions
+	_, err := s.parseArgs(args, &opts, "Run the commands read from stdin line by line and stop at the first one that fails")
+	if err != nil {
+		return s.reportError(err)
+	}
+	if s.isPty || s.started || s.scripting {
+		return s.reportError(fmt.Errorf("script reads the commands from stdin, use ssh user@server script < file"))
+	}
+	s.scripting = true
+	defer func() {
+		s.scripting = false
+	}()
+	for {
+		line, err := s.io.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return exitStatus{}, true
+		}
+		if err != nil {
+			return s.reportError(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		status, ok := s.execLine(line)
+		if !ok && status.ExitCode != 0 {
+			return status, false
+		}
+		if s.needStop {
+			return exitStatus{}, true
+		}
+	}
+}
+
 func (s *sessionIO) ReadLine() (string, error) {
 	var buff []byte
 	for {
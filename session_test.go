@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testChannel is a session channel, keys written to input are read by the
+// session and the exit status it sends goes to exitStatus.
+type testChannel struct {
+	input      *io.PipeWriter
+	reader     *io.PipeReader
+	exitStatus chan exitStatus
+	closed     chan struct{}
+	output     strings.Builder
+	lock       sync.Mutex
+	closeOnce  sync.Once
+}
+
+func newTestChannel() *testChannel {
+	reader, writer := io.Pipe()
+	return &testChannel{
+		input:      writer,
+		reader:     reader,
+		exitStatus: make(chan exitStatus, 1),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (c *testChannel) Read(data []byte) (int, error) {
+	return c.reader.Read(data)
+}
+
+func (c *testChannel) Write(data []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.output.Write(data)
+}
+
+func (c *testChannel) Output() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.output.String()
+}
+
+func (c *testChannel) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.input.Close()
+}
+
+func (c *testChannel) CloseWrite() error {
+	return nil
+}
+
+func (c *testChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	if name == "exit-status" {
+		var status exitStatus
+		if err := ssh.Unmarshal(payload, &status); err != nil {
+			return false, err
+		}
+		c.exitStatus <- status
+	}
+	return true, nil
+}
+
+func (c *testChannel) Stderr() io.ReadWriter {
+	return c
+}
+
+// newTestSession returns a session of conn without a terminal, reading
+// stdin from input.
+func newTestSession(conn *ssh.ServerConn, input string) (*session, *testChannel) {
+	channel := newTestChannel()
+	s := &session{conn: conn, channel: channel, io: &sessionIO{channel, bufio.NewReader(strings.NewReader(input))}}
+	return s, channel
+}
+
+func TestDrainCommand(t *testing.T) {
+	bindings = NewBindingManager()
+	conn := newTestServerConn("alice", "*.example.com")
+	if err := bindings.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"a.example.com", "b.example.com"} {
+		if err := bindings.AddBinding(conn, domain, 25565); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, _ := newTestSession(conn, "")
+	if err := s.handleDrainCommand([]string{"drain", "a.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if bindings.HasBinding("a.example.com") {
+		t.Errorf("Expected an empty binding to be removed right away [FAILED]")
+	}
+	upstream, _ := bindings.Lookup(conn, "b.example.com")
+	player := addTestConnection(upstream)
+	started := time.Now()
+	if err := s.handleDrainCommand([]string{"drain", "-t", "1", "b.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(started) < time.Second || bindings.HasBinding("b.example.com") {
+		t.Errorf("Expected the binding to be removed once the timeout is over [FAILED]")
+	}
+	select {
+	case <-player.closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the remaining player to be dropped [FAILED]")
+	}
+}
+
+func TestDrainOtherUsersBinding(t *testing.T) {
+	bindings = NewBindingManager()
+	owner := newTestServerConn("alice", "*.example.com")
+	other := newTestServerConn("bob", "*.example.com")
+	for _, conn := range []*ssh.ServerConn{owner, other} {
+		if err := bindings.AddConnection(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bindings.AddBinding(owner, "a.example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestSession(other, "")
+	if err := s.handleDrainCommand([]string{"drain", "a.example.com"}); err == nil {
+		t.Errorf("Expected draining another user's binding to be refused [FAILED]")
+	}
+	if upstream, ok := bindings.Resolve("a.example.com"); !ok || upstream.Draining() {
+		t.Errorf("Expected the binding to stay untouched [FAILED]")
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	cases := map[string][]commandStep{
+		"list":                    {{"list", false}},
+		"proxy -E a; list":        {{"proxy -E a", false}, {"list", false}},
+		"proxy -E a && list -a":   {{"proxy -E a", false}, {"list -a", true}},
+		"a&&b;c":                  {{"a", false}, {"b", true}, {"c", false}},
+		`motd set a "x; y && z"`:  {{`motd set a "x; y && z"`, false}},
+		`motd set a 'it''s; ok'`:  {{`motd set a 'it''s; ok'`, false}},
+		`motd set a x\;y`:         {{`motd set a x\;y`, false}},
+		"list;; list;":            {{"list", false}, {"list", false}},
+		"":                        nil,
+		"  ":                      nil,
+		"ban a 1.2.3.4 & unban a": {{"ban a 1.2.3.4 & unban a", false}},
+	}
+	for line, expected := range cases {
+		steps, err := splitCommands(line)
+		if err != nil || !reflect.DeepEqual(steps, expected) {
+			t.Errorf("Expected %q to be split into %v, got %v (%v) [FAILED]", line, expected, steps, err)
+		}
+	}
+	for _, line := range []string{"&& list", "list &&", "list && ; list", "list &&&& list"} {
+		if _, err := splitCommands(line); err == nil {
+			t.Errorf("Expected %q to be rejected [FAILED]", line)
+		}
+	}
+}
+
+func TestScriptDoesNotReadStdinTwice(t *testing.T) {
+	bindings = NewBindingManager()
+	serverStatuses = NewMap[string, ServerStatus]()
+	serverStatusPatterns = NewMatcher[string]()
+	conn := newTestServerConn("alice", "example.com")
+	if err := bindings.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindings.AddBinding(conn, "example.com", 25565); err != nil {
+		t.Fatal(err)
+	}
+	s, channel := newTestSession(conn, "favicon set example.com\nmotd set example.com 'Back soon'\n")
+	status, ok := s.run("script")
+	if ok || status.ExitCode != 1 {
+		t.Errorf("Expected the script to fail with the status of favicon, got %d [FAILED]", status.ExitCode)
+	}
+	if serverStatuses.Contains("example.com") {
+		t.Errorf("Expected the script to stop at the failing line [FAILED]")
+	}
+	if lines := strings.Split(strings.TrimSpace(channel.Output()), "\n"); len(lines) != 1 || !strings.Contains(lines[0], "argument") {
+		t.Errorf("Expected only the error of favicon to be printed, got %q [FAILED]", channel.Output())
+	}
+}
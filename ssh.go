@@ -11,15 +11,42 @@ import (
 	"net"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type UserConfig struct {
-	Password        string   `yaml:"password"`
-	AuthorizedKeys  []string `yaml:"authorized_keys"`
-	AllowedBindings []string `yaml:"allowed_bindings"`
+	Password        string              `yaml:"password"`
+	AuthorizedKeys  []string            `yaml:"authorized_keys"`
+	AllowedBindings []string            `yaml:"allowed_bindings"`
+	TOTPSecret      string              `yaml:"totp_secret"`
+	Admin           bool                `yaml:"admin"`
+	Aliases         map[string][]string `yaml:"aliases"`
+	MOTD            map[string]string   `yaml:"motd"`
+	Favicon         map[string]string   `yaml:"favicon"`
+	Limits          UserLimits          `yaml:",inline"`
 }
 
+// UserLimits caps what a user may open at the same time, 0 means unlimited.
+type UserLimits struct {
+	MaxConnections       int `yaml:"max_connections"`
+	MaxBindings          int `yaml:"max_bindings"`
+	MaxPlayerConnections int `yaml:"max_player_connections"`
+}
+
+// Keys of ssh.Permissions.Extensions that carry user settings from the auth
+// callbacks to the connection. Every other key is an allowed binding, the
+// "@" keeps them apart from domain patterns.
+const (
+	extensionMaxConnections       = "max-connections@mcrouter"
+	extensionMaxBindings          = "max-bindings@mcrouter"
+	extensionMaxPlayerConnections = "max-player-connections@mcrouter"
+	extensionAdmin                = "admin@mcrouter"
+	extensionAliases              = "aliases@mcrouter"
+)
+
 func handleSSH(conn net.Conn, config *ssh.ServerConfig) {
 	sshConn, channels, requests, err := ssh.NewServerConn(conn, config)
 
@@ -27,19 +54,47 @@ func handleSSH(conn net.Conn, config *ssh.ServerConfig) {
 		return
 	}
 
-	_ = bindings.AddConnection(sshConn)
+	err = bindings.AddConnection(sshConn)
+	if err != nil {
+		log.Printf("[SSH] %v (%s) is rejected: %v", hex.EncodeToString(sshConn.SessionID()), sshConn.User(), err)
+		go rejectConnection(sshConn, channels, requests, err)
+		return
+	}
+
+	info := NewConnectionInfo(sshConn)
+	connectionInfos.Set(sshConn, info)
 
 	go func() {
 		_ = sshConn.Wait()
 		bindings.RemoveConnection(sshConn)
-		log.Printf("[SSH] %v disconnected", hex.EncodeToString(sshConn.SessionID()))
+		log.Printf("[SSH] %v disconnected: %s after %v (last rtt %s)", info.SessionID, info, time.Since(info.ConnectedAt).Round(time.Second), formatRoundTrip(sshConn))
+		roundTrips.Remove(sshConn)
+		connectionInfos.Remove(sshConn)
 	}()
 
-	log.Printf("[SSH] %v connected", hex.EncodeToString(sshConn.SessionID()))
+	log.Printf("[SSH] %v connected: %s", info.SessionID, info)
 
 	go handleRequests(sshConn, requests)
 	go handleChannels(sshConn, channels)
 	go handleKeepAlive(sshConn)
+	go announceHostKeys(sshConn)
+}
+
+// rejectConnection refuses everything the client asks for with the reason it
+// was rejected, so it shows up on the client side, then hangs up.
+func rejectConnection(sshConn *ssh.ServerConn, channels <-chan ssh.NewChannel, requests <-chan *ssh.Request, reason error) {
+	go func() {
+		for req := range requests {
+			replyWith(req, false, nil)
+		}
+	}()
+	go func() {
+		for newChannel := range channels {
+			_ = newChannel.Reject(ssh.Prohibited, reason.Error())
+		}
+	}()
+	time.Sleep(5 * time.Second)
+	_ = sshConn.Close()
 }
 
 func handleChannels(sshConn *ssh.ServerConn, channels <-chan ssh.NewChannel) {
@@ -51,12 +106,70 @@ func handleChannels(sshConn *ssh.ServerConn, channels <-chan ssh.NewChannel) {
 				continue
 			}
 			go handleSession(sshConn, channel, requests)
+		case "direct-tcpip":
+			go handleDirectTCPIP(sshConn, newChannel)
 		default:
 			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 		}
 	}
 }
 
+// handleDirectTCPIP lets a user reach their own binding through the router
+// with ssh -L, to check the whole path without public DNS.
+func handleDirectTCPIP(sshConn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	payload := forwardedTCPPayload{}
+	err := ssh.Unmarshal(newChannel.ExtraData(), &payload)
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+	upstream, ok := bindings.Resolve(payload.Addr)
+	if !ok || upstream.SSHConn().User() != sshConn.User() {
+		_ = newChannel.Reject(ssh.Prohibited, fmt.Sprintf("no binding of yours for %s", payload.Addr))
+		return
+	}
+	if ip := remoteIP(sshConn.RemoteAddr()); ip != nil {
+		_, banned := banList.Check(ip)
+		if list, ok := BindingBans(upstream, false); ok && !banned {
+			_, banned = list.Check(ip)
+		}
+		if banned {
+			_ = newChannel.Reject(ssh.Prohibited, fmt.Sprintf("%s is banned from %s", ip, payload.Addr))
+			return
+		}
+	}
+	release, err := bindings.ReservePlayer(upstream)
+	if err != nil {
+		_ = newChannel.Reject(ssh.ResourceShortage, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		release()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	// the origin in the payload is chosen by the client, the backend and the
+	// bans see the address of the SSH connection instead
+	downstream := &forwardedConn{
+		remoteAddr: sshConn.RemoteAddr(),
+		localAddr:  sshConn.LocalAddr(),
+		channel:    channel,
+	}
+	upConn, err := upstream.Dial(downstream, "")
+	release()
+	if err != nil {
+		log.Printf("[SSH] Failed to connect upstream %s for %v, %v", upstream.Domain(), hex.EncodeToString(sshConn.SessionID()), err)
+		addEvent(upstream, EventError, downstream.RemoteAddr().String(), "failed to connect upstream: %v", err)
+		_ = downstream.Close()
+		return
+	}
+	addEvent(upstream, EventHandshake, downstream.RemoteAddr().String(), "direct-tcpip")
+	reason := forward(downstream, upConn, upstream.IdleTimeout())
+	log.Printf("[SSH] %v disconnected from %s: %s", hex.EncodeToString(sshConn.SessionID()), upstream.Domain(), reason)
+	addEvent(upstream, EventDisconnect, downstream.RemoteAddr().String(), "%s", reason)
+}
+
 func handleRequests(sshConn *ssh.ServerConn, requests <-chan *ssh.Request) {
 	for req := range requests {
 		switch req.Type {
@@ -76,32 +189,49 @@ func handleRequests(sshConn *ssh.ServerConn, requests <-chan *ssh.Request) {
 				replyWith(req, false, nil)
 				continue
 			}
+			for _, alias := range configuredAliases(sshConn.Permissions, payload.Addr) {
+				err = bindings.AddAlias(sshConn, payload.Addr, alias)
+				if err != nil {
+					log.Printf("[SSH] alias %s for %v (%s) is rejected: %v", alias, hex.EncodeToString(sshConn.SessionID()), payload.Addr, err)
+				}
+			}
+			if upstream, err := bindings.Lookup(sshConn, payload.Addr); err == nil {
+				err = applyConfiguredStatus(upstream)
+				if err != nil {
+					log.Printf("[SSH] server list entry for %v (%s) is rejected: %v", hex.EncodeToString(sshConn.SessionID()), payload.Addr, err)
+				}
+			}
 			port := replyPort{Port: payload.Port}
 			reply := ssh.Marshal(&port)
 			replyWith(req, true, reply)
+		case "hostkeys-prove-00@openssh.com":
+			reply, err := proveHostKeys(sshConn, req.Payload)
+			replyWith(req, err == nil, reply)
+		case "cancel-tcpip-forward":
+			payload := tcpipForwardPayload{}
+			err := ssh.Unmarshal(req.Payload, &payload)
+			if err != nil {
+				replyWith(req, false, nil)
+				continue
+			}
+			_, err = bindings.Lookup(sshConn, payload.Addr)
+			if err != nil {
+				log.Printf("[SSH] cancelling binding for %v (%s:%d) is rejected: %v", hex.EncodeToString(sshConn.SessionID()), payload.Addr, payload.Port, err)
+				replyWith(req, false, nil)
+				continue
+			}
+			bindings.RemoveBinding(payload.Addr)
+			log.Printf("[SSH] binding for %v (%s:%d) is cancelled", hex.EncodeToString(sshConn.SessionID()), payload.Addr, payload.Port)
+			replyWith(req, true, nil)
 		default:
 			replyWith(req, false, nil)
 		}
 	}
 }
 
-func handleKeepAlive(sshConn *ssh.ServerConn) {
-	ticker := time.NewTicker(5 * time.Second)
-	go func() {
-		_ = sshConn.Wait()
-		ticker.Stop()
-	}()
-	for range ticker.C {
-		_, _, err := sshConn.SendRequest("keepalive@minecraft", true, nil)
-		if err != nil {
-			_ = sshConn.Close()
-			return
-		}
-	}
-}
-
 func handleSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) {
 	var pty *term.Terminal = nil
+	var input *ptyInput = nil
 request:
 	for req := range requests {
 		switch req.Type {
@@ -123,12 +253,13 @@ request:
 				replyWith(req, false, nil)
 				continue
 			}
-			pty = term.NewTerminal(channel, "> ")
+			input = newPtyInput(channel)
+			pty = term.NewTerminal(input, "> ")
 			_ = (*pty).SetSize(int(ptyReq.Columns), int(ptyReq.Rows))
 			replyWith(req, true, nil)
 		case "shell", "exec":
 			go func() {
-				session := NewSession(conn, channel, requests, pty)
+				session := NewSession(conn, channel, requests, pty, input)
 				if req.Type == "exec" {
 					var cmd = struct {
 						Command string
@@ -155,6 +286,22 @@ request:
 }
 
 func handleSSHPublicKeyAuth(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if err := checkAuthAllowed(conn); err != nil {
+		return nil, err
+	}
+
+	if revokedKeys.IsRevoked(key) {
+		return nil, fmt.Errorf("key is revoked")
+	}
+
+	if cert, ok := key.(*ssh.Certificate); ok {
+		permissions, err := handleSSHCertificateAuth(conn, cert)
+		if err != nil {
+			return nil, err
+		}
+		return withAuthInfo(permissions, "certificate", cert.Key), nil
+	}
+
 	config, err := loadConfig(conn.User())
 	if err != nil {
 		return nil, err
@@ -163,7 +310,7 @@ func handleSSHPublicKeyAuth(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Perm
 	key1 := key.Marshal()
 
 	for _, authorizedKey := range config.AuthorizedKeys {
-		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+		pub, _, keyOptions, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
 
 		if err != nil {
 			continue
@@ -188,14 +335,38 @@ func handleSSHPublicKeyAuth(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Perm
 			permissions.Extensions[binding] = binding
 		}
 
-		return userPermission(config), nil
+		options, err := ParseKeyOptions(keyOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		if options.Expired(time.Now()) {
+			return nil, fmt.Errorf("key has expired")
+		}
+
+		if !options.AllowsAddress(remoteIP(conn.RemoteAddr())) {
+			return nil, fmt.Errorf("key is not allowed from %v", conn.RemoteAddr())
+		}
+
+		if config.TOTPSecret != "" {
+			return nil, secondFactor(config, options.Apply(userPermission(config)), "publickey", key)
+		}
+
+		return withAuthInfo(options.Apply(userPermission(config)), "publickey", key), nil
 	}
 
 	return nil, fmt.Errorf("no matching key found")
 }
 
 func handlePasswordAuth(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
-	return handlePassword(conn.User(), string(password))
+	if err := checkAuthAllowed(conn); err != nil {
+		return nil, err
+	}
+	permissions, err := handlePassword(conn.User(), string(password))
+	if err != nil {
+		return nil, err
+	}
+	return withAuthInfo(permissions, "password", nil), nil
 }
 
 func handlePassword(username string, password string) (*ssh.Permissions, error) {
@@ -205,20 +376,32 @@ func handlePassword(username string, password string) (*ssh.Permissions, error)
 		return nil, err
 	}
 
+	err = verifyPassword(username, config, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TOTPSecret != "" {
+		return nil, secondFactor(config, userPermission(config), "password", nil)
+	}
+
+	return userPermission(config), nil
+}
+
+func verifyPassword(username string, config *UserConfig, password string) error {
 	if config.Password == "" {
-		return nil, fmt.Errorf("password not set")
+		return fmt.Errorf("password not set")
 	}
 
-	if len(config.Password) != len(password) {
-		subtle.ConstantTimeCompare([]byte(password), []byte(password))
-		return nil, fmt.Errorf("password mismatch")
+	if !checkPassword(config.Password, password) {
+		return fmt.Errorf("password mismatch")
 	}
 
-	if subtle.ConstantTimeCompare([]byte(config.Password), []byte(password)) == 1 {
-		return userPermission(config), nil
+	if !isPasswordHash(config.Password) {
+		log.Printf("[SSH] password of %s is stored in plaintext, replace it with the output of mcrouter hash-password", username)
 	}
 
-	return nil, fmt.Errorf("password mismatch")
+	return nil
 }
 
 func loadConfig(user string) (*UserConfig, error) {
@@ -248,9 +431,63 @@ func userPermission(config *UserConfig) *ssh.Permissions {
 		permissions.Extensions[binding] = binding
 	}
 
+	permissions.Extensions[extensionMaxConnections] = strconv.Itoa(config.Limits.MaxConnections)
+	permissions.Extensions[extensionMaxBindings] = strconv.Itoa(config.Limits.MaxBindings)
+	permissions.Extensions[extensionMaxPlayerConnections] = strconv.Itoa(config.Limits.MaxPlayerConnections)
+
+	if config.Admin {
+		permissions.Extensions[extensionAdmin] = "true"
+	}
+
+	var aliases []string
+	for binding, hostnames := range config.Aliases {
+		for _, hostname := range hostnames {
+			aliases = append(aliases, hostname+"="+binding)
+		}
+	}
+	if len(aliases) > 0 {
+		sort.Strings(aliases)
+		permissions.Extensions[extensionAliases] = strings.Join(aliases, ",")
+	}
+
+	for binding, motd := range config.MOTD {
+		permissions.Extensions[extensionMOTD+binding] = motd
+	}
+	for binding, file := range config.Favicon {
+		permissions.Extensions[extensionFavicon+binding] = file
+	}
+
 	return &permissions
 }
 
+func isSettingExtension(key string) bool {
+	return strings.Contains(key, "@")
+}
+
+func permissionLimits(permissions *ssh.Permissions) UserLimits {
+	limit := func(key string) int {
+		value, _ := strconv.Atoi(permissions.Extensions[key])
+		return value
+	}
+	return UserLimits{
+		MaxConnections:       limit(extensionMaxConnections),
+		MaxBindings:          limit(extensionMaxBindings),
+		MaxPlayerConnections: limit(extensionMaxPlayerConnections),
+	}
+}
+
+// configuredAliases returns the aliases the user config lists for a binding.
+func configuredAliases(permissions *ssh.Permissions, pattern string) []string {
+	var aliases []string
+	for _, entry := range strings.Split(permissions.Extensions[extensionAliases], ",") {
+		alias, binding, found := strings.Cut(entry, "=")
+		if found && binding == pattern {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
 func replyWith(req *ssh.Request, ok bool, payload []byte) {
 	if req.WantReply {
 		_ = req.Reply(ok, payload)
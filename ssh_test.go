@@ -0,0 +1,164 @@
+package main
+
+import (
+	"golang.org/x/crypto/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+func forwardRequest(kind string, domain string) *ssh.Request {
+	return &ssh.Request{Type: kind, Payload: ssh.Marshal(&tcpipForwardPayload{Addr: domain, Port: 25565})}
+}
+
+// sendRequests hands the global requests to handleRequests as if conn had
+// sent them, and returns once they are handled.
+func sendRequests(conn *ssh.ServerConn, requests ...*ssh.Request) {
+	queue := make(chan *ssh.Request, len(requests))
+	for _, req := range requests {
+		queue <- req
+	}
+	close(queue)
+	handleRequests(conn, queue)
+}
+
+func TestCancelTCPIPForward(t *testing.T) {
+	bindings = NewBindingManager()
+	owner := newTestServerConn("alice", "*.example.com")
+	other := newTestServerConn("bob", "*.example.com")
+	for _, conn := range []*ssh.ServerConn{owner, other} {
+		if err := bindings.AddConnection(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sendRequests(owner, forwardRequest("tcpip-forward", "a.example.com"))
+	upstream, ok := bindings.Resolve("a.example.com")
+	if !ok {
+		t.Fatalf("Expected the binding to be registered [FAILED]")
+	}
+	player := addTestConnection(upstream)
+	sendRequests(other, forwardRequest("cancel-tcpip-forward", "a.example.com"))
+	if !bindings.HasBinding("a.example.com") {
+		t.Errorf("Expected another user not to cancel the binding [FAILED]")
+	}
+	sendRequests(owner, forwardRequest("cancel-tcpip-forward", "a.example.com"))
+	if bindings.HasBinding("a.example.com") {
+		t.Errorf("Expected the owner to cancel the binding [FAILED]")
+	}
+	select {
+	case <-player.closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the players of a cancelled binding to be dropped [FAILED]")
+	}
+	if err := bindings.AddBinding(owner, "a.example.com", 25565); err != nil {
+		t.Errorf("Expected the domain to be free again, got %v [FAILED]", err)
+	}
+}
+
+// testNewChannel is a channel opened by a client, Accept hands out channel.
+type testNewChannel struct {
+	kind     string
+	extra    []byte
+	channel  *testChannel
+	rejected chan string
+}
+
+func newTestNewChannel(kind string, payload interface{}) *testNewChannel {
+	return &testNewChannel{kind: kind, extra: ssh.Marshal(payload), channel: newTestChannel(), rejected: make(chan string, 1)}
+}
+
+func (c *testNewChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	requests := make(chan *ssh.Request)
+	close(requests)
+	return c.channel, requests, nil
+}
+
+func (c *testNewChannel) Reject(reason ssh.RejectionReason, message string) error {
+	c.rejected <- message
+	return nil
+}
+
+func (c *testNewChannel) ChannelType() string {
+	return c.kind
+}
+
+func (c *testNewChannel) ExtraData() []byte {
+	return c.extra
+}
+
+// forwardingSSHConn hands out upstream when the router opens a
+// forwarded-tcpip channel to the tunnel client.
+type forwardingSSHConn struct {
+	*testSSHConn
+	upstream *testChannel
+	payload  forwardedTCPPayload
+}
+
+func (c *forwardingSSHConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+}
+
+func (c *forwardingSSHConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+}
+
+func (c *forwardingSSHConn) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	if err := ssh.Unmarshal(data, &c.payload); err != nil {
+		return nil, nil, err
+	}
+	requests := make(chan *ssh.Request)
+	close(requests)
+	return c.upstream, requests, nil
+}
+
+func TestDirectTCPIP(t *testing.T) {
+	bindings = NewBindingManager()
+	owner := newTestServerConn("alice", "*.example.com")
+	tunnel := &forwardingSSHConn{testSSHConn: owner.Conn.(*testSSHConn), upstream: newTestChannel()}
+	owner.Conn = tunnel
+	other := newTestServerConn("bob", "*.example.com")
+	for _, conn := range []*ssh.ServerConn{owner, other} {
+		if err := bindings.AddConnection(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sendRequests(owner, forwardRequest("tcpip-forward", "a.example.com"))
+	payload := forwardedTCPPayload{Addr: "a.example.com", Port: 25565, OriginAddr: "127.0.0.1", OriginPort: 4000}
+
+	newChannel := newTestNewChannel("direct-tcpip", &payload)
+	handleDirectTCPIP(other, newChannel)
+	if len(newChannel.rejected) != 1 {
+		t.Errorf("Expected a binding of another user to be refused [FAILED]")
+	}
+	unknown := payload
+	unknown.Addr = "b.example.com"
+	newChannel = newTestNewChannel("direct-tcpip", &unknown)
+	handleDirectTCPIP(owner, newChannel)
+	if len(newChannel.rejected) != 1 {
+		t.Errorf("Expected an unknown binding to be refused [FAILED]")
+	}
+
+	newChannel = newTestNewChannel("direct-tcpip", &payload)
+	done := make(chan struct{})
+	go func() {
+		handleDirectTCPIP(owner, newChannel)
+		close(done)
+	}()
+	_, _ = newChannel.channel.input.Write([]byte("ping"))
+	for deadline := time.Now().Add(5 * time.Second); tunnel.upstream.Output() != "ping"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the data to reach the tunnel client, got %q [FAILED]", tunnel.upstream.Output())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if tunnel.payload.Addr != "a.example.com" {
+		t.Errorf("Expected the tunnel client to be told the binding, got %q [FAILED]", tunnel.payload.Addr)
+	}
+	_ = newChannel.channel.Close()
+	_ = tunnel.upstream.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected the forwarding to end with the channel [FAILED]")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+type stateFile struct {
+	Usage map[string]map[string]TrafficStats `json:"usage"`
+}
+
+func loadState(file string) error {
+	binary, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state stateFile
+	err = json.Unmarshal(binary, &state)
+	if err != nil {
+		return err
+	}
+	usage.Restore(state.Usage)
+	return nil
+}
+
+func saveState(file string) error {
+	state := stateFile{
+		Usage: usage.Snapshot(),
+	}
+	binary, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(path.Dir(file), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	_, err = tmp.Write(binary)
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func persistState() {
+	for {
+		time.Sleep(time.Minute)
+		err := saveState(opts.StateFile)
+		if err != nil {
+			log.Printf("Failed to save state to %s: %v", opts.StateFile, err)
+		}
+	}
+}
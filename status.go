@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/Tnze/go-mc/net/packet"
+	"image/png"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const faviconPrefix = "data:image/png;base64,"
+
+// The status response is a string of at most 32767 characters, the MOTD and
+// the base64 encoded favicon have to fit in it together.
+const (
+	maxMOTDSize    = 4 << 10
+	maxFaviconSize = 12 << 10
+)
+
+// Prefixes of ssh.Permissions.Extensions keys carrying the MOTD and favicon
+// file of a binding from the user config, followed by the binding pattern.
+const (
+	extensionMOTD    = "motd@mcrouter:"
+	extensionFavicon = "favicon@mcrouter:"
+)
+
+// serverStatusLifetime is how long a server list entry is kept once its
+// binding is no longer registered by the owner.
+const serverStatusLifetime = 7 * 24 * time.Hour
+
+// ServerStatus is what the router answers to a server list ping of a binding
+// whose tunnel cannot be reached.
+type ServerStatus struct {
+	User    string
+	MOTD    json.RawMessage
+	Favicon string
+	Expires time.Time
+}
+
+// serverStatuses holds the server list entries by binding pattern. They are
+// kept after the binding is gone, so the router can answer for it, and
+// serverStatusPatterns finds the pattern of a host.
+var serverStatuses = NewMap[string, ServerStatus]()
+var serverStatusPatterns = NewMatcher[string]()
+var serverStatusesLock sync.Mutex
+
+// UpdateServerStatus changes the server list entry of a binding of user, an
+// entry of another user is replaced rather than updated.
+func UpdateServerStatus(user string, domain string, update func(status *ServerStatus)) {
+	serverStatusesLock.Lock()
+	defer serverStatusesLock.Unlock()
+	status, ok := serverStatuses.Get(domain)
+	if !ok || status.User != user {
+		status = ServerStatus{User: user}
+	}
+	update(&status)
+	if status.MOTD == nil && status.Favicon == "" {
+		removeServerStatus(domain)
+		return
+	}
+	status.Expires = time.Now().Add(serverStatusLifetime)
+	serverStatuses.Set(domain, status)
+	if !serverStatusPatterns.Contains(domain) {
+		_ = serverStatusPatterns.Set(domain, domain)
+	}
+}
+
+func removeServerStatus(domain string) {
+	serverStatuses.Remove(domain)
+	serverStatusPatterns.Remove(domain)
+}
+
+// LookupServerStatus returns the server list entry for a handshake. With an
+// upstream only the entry of its owner is used. Without one the host is
+// matched against the binding patterns, and the entry is only used while it
+// has not expired and a connected owner is still allowed to register the
+// pattern. The user config of a disconnected owner is not read on every ping,
+// CleanupServerStatuses drops the entry once it no longer allows the pattern.
+func LookupServerStatus(host string, upstream McUpstream) (ServerStatus, bool) {
+	if upstream != nil {
+		status, ok := serverStatuses.Get(upstream.Domain())
+		return status, ok && status.User == upstream.SSHConn().User()
+	}
+	pattern, ok := serverStatusPatterns.Match(host)
+	if !ok {
+		return ServerStatus{}, false
+	}
+	status, ok := serverStatuses.Get(pattern)
+	if !ok || time.Now().After(status.Expires) {
+		return ServerStatus{}, false
+	}
+	if allowed, connected := bindings.UserAllows(status.User, pattern); connected && !allowed {
+		return ServerStatus{}, false
+	}
+	return status, true
+}
+
+// userAllowsBinding checks the permission of a connected user, or else the
+// user config. Users logging in with a certificate only cannot be checked
+// without the certificate, so they are not allowed while disconnected.
+func userAllowsBinding(user string, pattern string) bool {
+	if allowed, connected := bindings.UserAllows(user, pattern); connected {
+		return allowed
+	}
+	config, err := loadConfig(user)
+	if err != nil {
+		return false
+	}
+	validator := NewMatcher[bool]()
+	for _, binding := range config.AllowedBindings {
+		_ = validator.Set(binding, true)
+	}
+	_, ok := validator.MatchPattern(pattern)
+	return ok
+}
+
+// CleanupServerStatuses keeps the entries of registered bindings alive, and
+// drops expired entries and those their owner may no longer use.
+func CleanupServerStatuses() {
+	serverStatusesLock.Lock()
+	defer serverStatusesLock.Unlock()
+	now := time.Now()
+	renewed := make(map[string]ServerStatus)
+	var removed []string
+	_ = serverStatuses.Each(func(domain string, status ServerStatus) error {
+		registered := false
+		_ = bindings.EachUserBinding(status.User, func(upstream McUpstream) error {
+			registered = registered || upstream.Domain() == domain
+			return nil
+		})
+		if registered {
+			status.Expires = now.Add(serverStatusLifetime)
+			renewed[domain] = status
+		} else if now.After(status.Expires) || !userAllowsBinding(status.User, domain) {
+			removed = append(removed, domain)
+		}
+		return nil
+	})
+	for domain, status := range renewed {
+		serverStatuses.Set(domain, status)
+	}
+	for _, domain := range removed {
+		removeServerStatus(domain)
+	}
+}
+
+// parseMOTD accepts a JSON text component, or plain text which is wrapped
+// into one.
+func parseMOTD(value string) (json.RawMessage, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("empty MOTD")
+	}
+	if len(value) > maxMOTDSize {
+		return nil, fmt.Errorf("MOTD is larger than %s", formatBytes(maxMOTDSize))
+	}
+	if strings.ContainsAny(value[:1], "{[\"") {
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("invalid JSON text component")
+		}
+		return json.RawMessage(value), nil
+	}
+	return json.Marshal(McMessage{Text: value})
+}
+
+// parseFavicon turns a base64 encoded PNG, with or without the data URI
+// prefix, into the data URI sent to clients. Clients only show 64x64 icons.
+func parseFavicon(value string) (string, error) {
+	value = strings.Join(strings.Fields(value), "")
+	value = strings.TrimPrefix(value, faviconPrefix)
+	if base64.StdEncoding.DecodedLen(len(value)) > maxFaviconSize+2 {
+		return "", fmt.Errorf("favicon is larger than %s", formatBytes(maxFaviconSize))
+	}
+	binary, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %v", err)
+	}
+	return encodeFavicon(binary)
+}
+
+func encodeFavicon(binary []byte) (string, error) {
+	if len(binary) > maxFaviconSize {
+		return "", fmt.Errorf("favicon is larger than %s", formatBytes(maxFaviconSize))
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(binary))
+	if err != nil {
+		return "", fmt.Errorf("invalid PNG: %v", err)
+	}
+	if config.Width != 64 || config.Height != 64 {
+		return "", fmt.Errorf("favicon must be 64x64, got %dx%d", config.Width, config.Height)
+	}
+	return faviconPrefix + base64.StdEncoding.EncodeToString(binary), nil
+}
+
+// applyConfiguredStatus sets the MOTD and favicon the user config lists for
+// a newly registered binding. Favicon files are relative to the auth
+// directory.
+func applyConfiguredStatus(upstream McUpstream) error {
+	extensions := upstream.SSHConn().Permissions.Extensions
+	var (
+		motd    json.RawMessage
+		favicon string
+		err     error
+	)
+	if value, ok := extensions[extensionMOTD+upstream.Domain()]; ok {
+		motd, err = parseMOTD(value)
+		if err != nil {
+			return err
+		}
+	}
+	if file, ok := extensions[extensionFavicon+upstream.Domain()]; ok {
+		if !path.IsAbs(file) {
+			file = path.Join(opts.SSHAuth, file)
+		}
+		binary, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		favicon, err = encodeFavicon(binary)
+		if err != nil {
+			return err
+		}
+	}
+	if motd == nil && favicon == "" {
+		return nil
+	}
+	UpdateServerStatus(upstream.SSHConn().User(), upstream.Domain(), func(status *ServerStatus) {
+		if motd != nil {
+			status.MOTD = motd
+		}
+		if favicon != "" {
+			status.Favicon = favicon
+		}
+	})
+	return nil
+}
+
+// answerStatus plays the server for a server list ping: it answers the
+// status request with the stored entry and the ping that follows it.
+func answerStatus(downstream net.Conn, protocol int32, status ServerStatus) error {
+	_ = downstream.SetDeadline(time.Now().Add(10 * time.Second))
+	request := &packet.Packet{}
+	err := request.UnPack(downstream, -1)
+	if err != nil {
+		return err
+	}
+	if request.ID != 0 {
+		return fmt.Errorf("unexpected packet %d", request.ID)
+	}
+	var response struct {
+		Version struct {
+			Name     string `json:"name"`
+			Protocol int32  `json:"protocol"`
+		} `json:"version"`
+		Players struct {
+			Max    int `json:"max"`
+			Online int `json:"online"`
+		} `json:"players"`
+		Description json.RawMessage `json:"description"`
+		Favicon     string          `json:"favicon,omitempty"`
+	}
+	response.Version.Name = "mcrouter"
+	response.Version.Protocol = protocol
+	response.Description = status.MOTD
+	if response.Description == nil {
+		response.Description, _ = json.Marshal(McMessage{Text: "Server is not available"})
+	}
+	response.Favicon = status.Favicon
+	binary, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	pack := packet.Marshal(0x00, packet.String(binary))
+	err = pack.Pack(downstream, -1)
+	if err != nil {
+		return err
+	}
+	ping := &packet.Packet{}
+	err = ping.UnPack(downstream, -1)
+	if err != nil {
+		// clients may close the connection without pinging
+		return nil
+	}
+	var payload packet.Long
+	if ping.ID != 0x01 || ping.Scan(&payload) != nil {
+		return nil
+	}
+	pong := packet.Marshal(0x01, payload)
+	return pong.Pack(downstream, -1)
+}
+
+type statusOutput struct {
+	Domain  string          `json:"domain"`
+	MOTD    json.RawMessage `json:"motd,omitempty"`
+	Favicon string          `json:"favicon,omitempty"`
+}
+
+func (s *session) handleMOTDCommand(args []string) error {
+	var opts emptyCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Set what the server list shows while a binding is offline: motd set <domain> <json|text>, motd clear <domain> or motd show")
+	if err != nil {
+		return err
+	}
+	rest = rest[1:]
+	if len(rest) == 0 || rest[0] == "show" {
+		return s.showServerStatuses(false)
+	}
+	switch rest[0] {
+	case "set":
+		if len(rest) < 3 {
+			return fmt.Errorf("a binding and a MOTD must be specified")
+		}
+		upstreams, err := s.userBindings(rest[1:2])
+		if err != nil {
+			return err
+		}
+		motd, err := parseMOTD(strings.Join(rest[2:], " "))
+		if err != nil {
+			return err
+		}
+		UpdateServerStatus(s.conn.User(), upstreams[0].Domain(), func(status *ServerStatus) {
+			status.MOTD = motd
+		})
+		log.Printf("[SSH] %s set the MOTD of %s", s.conn.User(), upstreams[0].Domain())
+		if s.json {
+			return writeJSON(s.io, statusOutput{Domain: upstreams[0].Domain(), MOTD: motd})
+		}
+		_, _ = fmt.Fprintf(s.io, "Set the MOTD of %s\n", upstreams[0].Domain())
+	case "clear":
+		return s.clearServerStatus(rest[1:], func(status *ServerStatus) {
+			status.MOTD = nil
+		})
+	default:
+		return fmt.Errorf("unknown motd command: %s", rest[0])
+	}
+	return nil
+}
+
+func (s *session) handleFaviconCommand(args []string) error {
+	var opts emptyCommandOptions
+	rest, err := s.parseArgs(args, &opts, "Set the server list icon of a binding: favicon set <domain> [base64 png], favicon clear <domain> or favicon show")
+	if err != nil {
+		return err
+	}
+	rest = rest[1:]
+	if len(rest) == 0 || rest[0] == "show" {
+		return s.showServerStatuses(true)
+	}
+	switch rest[0] {
+	case "set":
+		if len(rest) < 2 {
+			return fmt.Errorf("a binding must be specified")
+		}
+		upstreams, err := s.userBindings(rest[1:2])
+		if err != nil {
+			return err
+		}
+		encoded := strings.Join(rest[2:], "")
+		if encoded == "" {
+			if s.isPty {
+				return fmt.Errorf("pass the favicon as argument, or on stdin without a terminal")
+			}
+			encoded, err = s.readInput(2 * base64.StdEncoding.EncodedLen(maxFaviconSize))
+			if err != nil {
+				return err
+			}
+		}
+		favicon, err := parseFavicon(encoded)
+		if err != nil {
+			return err
+		}
+		UpdateServerStatus(s.conn.User(), upstreams[0].Domain(), func(status *ServerStatus) {
+			status.Favicon = favicon
+		})
+		log.Printf("[SSH] %s set the favicon of %s", s.conn.User(), upstreams[0].Domain())
+		if s.json {
+			return writeJSON(s.io, statusOutput{Domain: upstreams[0].Domain(), Favicon: favicon})
+		}
+		_, _ = fmt.Fprintf(s.io, "Set the favicon of %s\n", upstreams[0].Domain())
+	case "clear":
+		return s.clearServerStatus(rest[1:], func(status *ServerStatus) {
+			status.Favicon = ""
+		})
+	default:
+		return fmt.Errorf("unknown favicon command: %s", rest[0])
+	}
+	return nil
+}
+
+// clearServerStatus drops part of the server list entries of the user, the
+// bindings do not have to be registered anymore.
+func (s *session) clearServerStatus(domains []string, clear func(status *ServerStatus)) error {
+	if len(domains) == 0 {
+		return fmt.Errorf("no bindings specified")
+	}
+	for _, domain := range domains {
+		if status, ok := serverStatuses.Get(domain); !ok || status.User != s.conn.User() {
+			return fmt.Errorf("%s has no server list entry", domain)
+		}
+	}
+	for _, domain := range domains {
+		UpdateServerStatus(s.conn.User(), domain, clear)
+		log.Printf("[SSH] %s cleared the server list entry of %s", s.conn.User(), domain)
+	}
+	if s.json {
+		return writeJSON(s.io, struct {
+			Cleared []string `json:"cleared"`
+		}{domains})
+	}
+	_, _ = fmt.Fprintf(s.io, "Cleared %s\n", strings.Join(domains, " "))
+	return nil
+}
+
+func (s *session) showServerStatuses(favicon bool) error {
+	var output struct {
+		Statuses []statusOutput `json:"statuses"`
+	}
+	output.Statuses = []statusOutput{}
+	_ = serverStatuses.Each(func(domain string, status ServerStatus) error {
+		if status.User != s.conn.User() || (favicon && status.Favicon == "") || (!favicon && status.MOTD == nil) {
+			return nil
+		}
+		output.Statuses = append(output.Statuses, statusOutput{Domain: domain, MOTD: status.MOTD, Favicon: status.Favicon})
+		return nil
+	})
+	sort.Slice(output.Statuses, func(i, j int) bool {
+		return output.Statuses[i].Domain < output.Statuses[j].Domain
+	})
+	if s.json {
+		return writeJSON(s.io, output)
+	}
+	writer := tabwriter.NewWriter(s.io, 2, 2, 2, ' ', 0)
+	if favicon {
+		_, _ = writer.Write([]byte("DOMAIN\tFAVICON\n"))
+	} else {
+		_, _ = writer.Write([]byte("DOMAIN\tMOTD\n"))
+	}
+	for _, status := range output.Statuses {
+		if favicon {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\n", status.Domain, formatBytes(uint64(base64.StdEncoding.DecodedLen(len(status.Favicon)-len(faviconPrefix)))))
+		} else {
+			_, _ = fmt.Fprintf(writer, "%s\t%s\n", status.Domain, status.MOTD)
+		}
+	}
+	return writer.Flush()
+}
+
+// readInput reads everything left on stdin of a command run without a
+// terminal, and fails once there is more than limit bytes.
+func (s *session) readInput(limit int) (string, error) {
+	stdin, ok := s.io.(*sessionIO)
+	if s.started || !ok {
+		return "", fmt.Errorf("stdin is only read by commands passed to ssh directly")
+	}
+	if s.scripting {
+		return "", fmt.Errorf("stdin holds the script, pass the value as argument")
+	}
+	input, err := io.ReadAll(io.LimitReader(stdin.reader, int64(limit)+1))
+	if err != nil {
+		return "", err
+	}
+	if len(input) > limit {
+		return "", fmt.Errorf("stdin is larger than %s", formatBytes(uint64(limit)))
+	}
+	return string(input), nil
+}
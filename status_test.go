@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMOTD(t *testing.T) {
+	cases := map[string]string{
+		"Back soon":                      `{"text":"Back soon"}`,
+		`{"text":"Back","color":"red"}`:  `{"text":"Back","color":"red"}`,
+		`["",{"text":"a"},{"text":"b"}]`: `["",{"text":"a"},{"text":"b"}]`,
+		`"quoted"`:                       `"quoted"`,
+		"  padded  ":                     `{"text":"padded"}`,
+		"§cRed \"quotes\" stay as is":    `{"text":"§cRed \"quotes\" stay as is"}`,
+	}
+	for value, expected := range cases {
+		motd, err := parseMOTD(value)
+		if err != nil || string(motd) != expected {
+			t.Errorf("Expected %q to be parsed as %s, got %s (%v) [FAILED]", value, expected, motd, err)
+		}
+	}
+	for _, value := range []string{"", "   ", `{"text":`, strings.Repeat("a", maxMOTDSize+1)} {
+		if _, err := parseMOTD(value); err == nil {
+			t.Errorf("Expected %q to be rejected [FAILED]", value)
+		}
+	}
+}
+
+func encodeTestPNG(t *testing.T, width int, height int) string {
+	var buffer bytes.Buffer
+	err := png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, width, height)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buffer.Bytes())
+}
+
+func TestParseFavicon(t *testing.T) {
+	encoded := encodeTestPNG(t, 64, 64)
+	for _, value := range []string{encoded, faviconPrefix + encoded, encoded[:20] + "\n" + encoded[20:] + "\n"} {
+		favicon, err := parseFavicon(value)
+		if err != nil || favicon != faviconPrefix+encoded {
+			t.Errorf("Expected favicon to be accepted, got %v [FAILED]", err)
+		}
+	}
+	if _, err := parseFavicon(encodeTestPNG(t, 32, 32)); err == nil || !strings.Contains(err.Error(), "64x64") {
+		t.Errorf("Expected a 32x32 favicon to be rejected [FAILED]")
+	}
+	if _, err := parseFavicon(base64.StdEncoding.EncodeToString([]byte("not a png"))); err == nil {
+		t.Errorf("Expected data that is not a PNG to be rejected [FAILED]")
+	}
+	binary, _ := base64.StdEncoding.DecodeString(encoded)
+	padded := base64.StdEncoding.EncodeToString(append(binary, make([]byte, maxFaviconSize)...))
+	if _, err := parseFavicon(padded); err == nil || !strings.Contains(err.Error(), "larger") {
+		t.Errorf("Expected a favicon over %d bytes to be rejected, got %v [FAILED]", maxFaviconSize, err)
+	}
+	if _, err := parseFavicon("not base64!"); err == nil {
+		t.Errorf("Expected invalid base64 to be rejected [FAILED]")
+	}
+}
+
+func TestLookupServerStatus(t *testing.T) {
+	bindings = NewBindingManager()
+	serverStatuses = NewMap[string, ServerStatus]()
+	serverStatusPatterns = NewMatcher[string]()
+	opts.SSHAuth = t.TempDir()
+	writeConfig := func(binding string) {
+		config := fmt.Sprintf("allowed_bindings:\n  - %q\n", binding)
+		if err := os.WriteFile(path.Join(opts.SSHAuth, "alice.yaml"), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("*.example.com")
+	UpdateServerStatus("alice", "*.example.com", func(status *ServerStatus) {
+		status.MOTD = json.RawMessage(`"Back soon"`)
+	})
+	if _, ok := LookupServerStatus("play.example.com", nil); !ok {
+		t.Errorf("Expected the entry of a wildcard binding to be served for a matching host [FAILED]")
+	}
+	if _, ok := LookupServerStatus("example.org", nil); ok {
+		t.Errorf("Expected no entry for a host outside the pattern [FAILED]")
+	}
+	status, _ := serverStatuses.Get("*.example.com")
+	status.Expires = time.Now().Add(-time.Minute)
+	serverStatuses.Set("*.example.com", status)
+	if _, ok := LookupServerStatus("play.example.com", nil); ok {
+		t.Errorf("Expected an expired entry not to be served [FAILED]")
+	}
+	CleanupServerStatuses()
+	if serverStatuses.Contains("*.example.com") || serverStatusPatterns.Contains("*.example.com") {
+		t.Errorf("Expected an expired entry to be dropped [FAILED]")
+	}
+	UpdateServerStatus("alice", "*.example.com", func(status *ServerStatus) {
+		status.MOTD = json.RawMessage(`"Back soon"`)
+	})
+	writeConfig("other.example.org")
+	CleanupServerStatuses()
+	if _, ok := LookupServerStatus("play.example.com", nil); ok || serverStatuses.Contains("*.example.com") {
+		t.Errorf("Expected the entry to be dropped once the config of the owner lost the binding [FAILED]")
+	}
+	UpdateServerStatus("alice", "*.example.com", func(status *ServerStatus) {
+		status.MOTD = json.RawMessage(`"Back soon"`)
+	})
+	conn := newTestServerConn("alice", "other.example.org")
+	if err := bindings.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LookupServerStatus("play.example.com", nil); ok {
+		t.Errorf("Expected the entry not to be served once the connected owner lost the binding [FAILED]")
+	}
+	bindings.RemoveConnection(conn)
+	conn = newTestServerConn("alice", "*.example.com")
+	if err := bindings.AddConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LookupServerStatus("play.example.com", nil); !ok {
+		t.Errorf("Expected the permission of the connected owner to be used [FAILED]")
+	}
+	bindings.RemoveConnection(conn)
+	CleanupServerStatuses()
+	if serverStatuses.Contains("*.example.com") {
+		t.Errorf("Expected the entry of a binding the owner lost to be dropped [FAILED]")
+	}
+}
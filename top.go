@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// ptyInput sits between the SSH channel and the term.Terminal of a session,
+// so a full screen command can take the keys while it runs. The channel is
+// read for the whole session, an exec command has to get its keys even
+// though the terminal only reads lines once the command is done.
+type ptyInput struct {
+	ssh.Channel
+	chunks   chan []byte
+	pending  []byte
+	err      error
+	keys     chan []byte
+	captured chan struct{}
+	lock     sync.Mutex
+}
+
+func newPtyInput(channel ssh.Channel) *ptyInput {
+	p := &ptyInput{
+		Channel:  channel,
+		chunks:   make(chan []byte),
+		captured: make(chan struct{}),
+	}
+	go p.readChannel()
+	return p
+}
+
+func (p *ptyInput) readChannel() {
+	defer close(p.chunks)
+	buf := make([]byte, 1024)
+	for {
+		n, err := p.Channel.Read(buf)
+		if n > 0 {
+			p.dispatch(append([]byte(nil), buf[:n]...))
+		}
+		if err != nil {
+			p.err = err
+			return
+		}
+	}
+}
+
+// dispatch hands the data to the capturing command, or to the terminal once
+// it reads. Data waiting for the terminal goes to a command capturing the
+// keys in the meantime.
+func (p *ptyInput) dispatch(data []byte) {
+	for {
+		p.lock.Lock()
+		keys, captured := p.keys, p.captured
+		p.lock.Unlock()
+		if keys != nil {
+			select {
+			case keys <- data:
+			default:
+			}
+			return
+		}
+		select {
+		case p.chunks <- data:
+			return
+		case <-captured:
+		}
+	}
+}
+
+func (p *ptyInput) Read(data []byte) (int, error) {
+	if len(p.pending) == 0 {
+		chunk, ok := <-p.chunks
+		if !ok {
+			return 0, p.err
+		}
+		p.pending = chunk
+	}
+	n := copy(data, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// Capture sends the keys to the returned channel instead of the terminal
+// until the returned function is called.
+func (p *ptyInput) Capture() (<-chan []byte, func()) {
+	keys := make(chan []byte, 16)
+	p.lock.Lock()
+	p.keys = keys
+	close(p.captured)
+	p.captured = make(chan struct{})
+	p.lock.Unlock()
+	return keys, func() {
+		p.lock.Lock()
+		p.keys = nil
+		p.lock.Unlock()
+	}
+}
+
+type topCommandOptions struct {
+	outputOptions
+	Delay  uint32 `short:"d" long:"delay" description:"Seconds between refreshes" default:"1"`
+	Events int    `short:"n" long:"events" description:"Number of recent events to show" default:"10"`
+}
+
+type topOutput struct {
+	Bindings []BindingStatus `json:"bindings"`
+	Events   []Event         `json:"events"`
+}
+
+func (s *session) handleTopCommand(args []string) error {
+	var opts topCommandOptions
+	_, err := s.parseArgs(args, &opts, "Show a live view of bindings and events, press q to quit")
+	if err != nil {
+		return err
+	}
+	if s.json {
+		output := topOutput{Bindings: s.bindingStatuses(), Events: events.Recent(s.conn.User(), opts.Events)}
+		return writeJSON(s.io, output)
+	}
+	if !s.isPty {
+		return fmt.Errorf("top requires a terminal, use ssh -t")
+	}
+	if opts.Delay == 0 {
+		opts.Delay = 1
+	}
+	keys, release := s.input.Capture()
+	defer release()
+	ticker := time.NewTicker(time.Duration(opts.Delay) * time.Second)
+	defer ticker.Stop()
+	previous := make(map[string]TrafficStats)
+	last := time.Now()
+	for {
+		now := time.Now()
+		s.drawTop(opts.Events, previous, now.Sub(last))
+		last = now
+		select {
+		case <-ticker.C:
+		case pressed := <-keys:
+			// q, Q or Ctrl-C
+			if strings.ContainsAny(string(pressed), "qQ\x03") {
+				_, _ = fmt.Fprint(s.io, "\033[H\033[2J")
+				return nil
+			}
+		case signal, ok := <-s.signals:
+			if !ok {
+				s.needStop = true
+				return nil
+			}
+			switch signal {
+			case "INT", "TERM", "KILL":
+				_, _ = fmt.Fprint(s.io, "\033[H\033[2J")
+				return nil
+			}
+		}
+	}
+}
+
+// drawTop renders one frame, throughput is computed from the traffic seen in
+// the previous frame, which is updated in place.
+func (s *session) drawTop(eventCount int, previous map[string]TrafficStats, elapsed time.Duration) {
+	var frame strings.Builder
+	frame.WriteString("\033[H\033[2J")
+	_, _ = fmt.Fprintf(&frame, "mcrouter top - %s - %s (press q to quit)\n\n", s.conn.User(), time.Now().Format(time.TimeOnly))
+	writer := tabwriter.NewWriter(&frame, 2, 2, 2, ' ', 0)
+	_, _ = writer.Write([]byte("DOMAIN\tPLAYERS\tIN/s\tOUT/s\tIN\tOUT\tRTT\n"))
+	seconds := elapsed.Seconds()
+	for _, status := range s.bindingStatuses() {
+		before, seen := previous[status.Domain]
+		inRate, outRate := "-", "-"
+		if seen && seconds > 0 {
+			inRate = formatBytes(uint64(float64(status.Traffic.BytesIn-before.BytesIn) / seconds))
+			outRate = formatBytes(uint64(float64(status.Traffic.BytesOut-before.BytesOut) / seconds))
+		}
+		previous[status.Domain] = status.Traffic
+		rtt := "-"
+		if status.RTTMillis != nil {
+			rtt = fmt.Sprintf("%.1fms", *status.RTTMillis)
+		}
+		_, _ = fmt.Fprintf(
+			writer, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			status.Domain, status.Connections, inRate, outRate,
+			formatBytes(status.Traffic.BytesIn), formatBytes(status.Traffic.BytesOut), rtt,
+		)
+	}
+	_ = writer.Flush()
+	frame.WriteString("\nRecent events:\n")
+	for _, event := range events.Recent(s.conn.User(), eventCount) {
+		_, _ = fmt.Fprintln(&frame, event)
+	}
+	_, _ = fmt.Fprint(s.io, frame.String())
+}
+
+// bindingStatuses returns the bindings of every connection of the session
+// user, sorted by domain.
+func (s *session) bindingStatuses() []BindingStatus {
+	statuses := []BindingStatus{}
+	_ = bindings.EachUserBinding(s.conn.User(), func(upstream McUpstream) error {
+		statuses = append(statuses, NewBindingStatus(upstream))
+		return nil
+	})
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Domain < statuses[j].Domain
+	})
+	return statuses
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+func TestTopQuitsWhenStartedThroughExec(t *testing.T) {
+	bindings = NewBindingManager()
+	channel := newTestChannel()
+	defer channel.Close()
+	requests := make(chan *ssh.Request, 2)
+	requests <- &ssh.Request{Type: "pty-req", Payload: ssh.Marshal(struct {
+		Term    string
+		Columns uint32
+		Rows    uint32
+		Width   uint32
+		Height  uint32
+		Modes   string
+	}{"xterm", 80, 24, 0, 0, ""})}
+	requests <- &ssh.Request{Type: "exec", Payload: ssh.Marshal(struct{ Command string }{"top"})}
+	go handleSession(newTestServerConn("alice"), channel, requests)
+	// top does not return before the key, so it has to be read while it runs
+	go func() {
+		_, _ = channel.input.Write([]byte("q"))
+	}()
+	select {
+	case status := <-channel.exitStatus:
+		if status.ExitCode != 0 {
+			t.Errorf("Expected top to exit with 0, got %d [FAILED]", status.ExitCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Expected q to end top started through exec [FAILED]")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	// totpSkew is the number of periods accepted before and after the
+	// current one, to allow for clock drift.
+	totpSkew = 1
+)
+
+// errNoSecondFactor is returned by keyboard-interactive authentication when
+// the user has no TOTP secret. Nothing was asked, so it is not counted as a
+// failed login.
+var errNoSecondFactor = errors.New("verification code not set")
+
+// lastTOTPCounter prevents a code from being used twice by the same user.
+var lastTOTPCounter = NewMap[string, uint64]()
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+func totpCode(key []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, key)
+	_ = binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// checkTOTP returns the counter of the period the code belongs to when the
+// code is valid at the given time.
+func checkTOTP(secret string, code string, now time.Time) (uint64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(key) == 0 {
+		return 0, false
+	}
+	code = strings.TrimSpace(code)
+	current := uint64(now.Unix() / totpPeriod)
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		counter := current + uint64(skew)
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+func verifyTOTP(user string, secret string, code string) bool {
+	counter, ok := checkTOTP(secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	if last, used := lastTOTPCounter.Get(user); used && counter <= last {
+		return false
+	}
+	lastTOTPCounter.Set(user, counter)
+	return true
+}
+
+// secondFactor is returned by the password and public key callbacks when the
+// first factor is right but the user still has to enter a TOTP code. The
+// client continues with keyboard-interactive authentication, which only asks
+// for the code and then grants the permissions of the first factor. The ssh
+// package only applies it after a signed public key request, key queries
+// are just answered with ok.
+func secondFactor(config *UserConfig, permissions *ssh.Permissions, method string, key ssh.PublicKey) error {
+	return &ssh.PartialSuccessError{Next: ssh.ServerAuthCallbacks{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			if err := checkAuthAllowed(conn); err != nil {
+				return nil, err
+			}
+			answers, err := challenge(conn.User(), "", []string{"Verification code: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 {
+				return nil, fmt.Errorf("unexpected number of answers")
+			}
+			if !verifyTOTP(conn.User(), config.TOTPSecret, answers[0]) {
+				return nil, fmt.Errorf("verification code mismatch")
+			}
+			return withAuthInfo(permissions, method+"+keyboard-interactive", key), nil
+		},
+	}}
+}
+
+// handleKeyboardInteractiveAuth asks users with a TOTP secret for the
+// password and the code together.
+func handleKeyboardInteractiveAuth(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	if err := checkAuthAllowed(conn); err != nil {
+		return nil, err
+	}
+
+	config, err := loadConfig(conn.User())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNoSecondFactor, err)
+	}
+
+	if config.TOTPSecret == "" {
+		return nil, errNoSecondFactor
+	}
+
+	answers, err := challenge(conn.User(), "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 2 {
+		return nil, fmt.Errorf("unexpected number of answers")
+	}
+
+	err = verifyPassword(conn.User(), config, answers[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !verifyTOTP(conn.User(), config.TOTPSecret, answers[1]) {
+		return nil, fmt.Errorf("verification code mismatch")
+	}
+
+	return withAuthInfo(userPermission(config), "password+keyboard-interactive", nil), nil
+}
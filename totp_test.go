@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 test vectors, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, code := range cases {
+		if _, ok := checkTOTP(secret, code, time.Unix(unix, 0)); !ok {
+			t.Errorf("Expected %s to be valid at %d [FAILED]", code, unix)
+		}
+		if _, ok := checkTOTP(secret, code, time.Unix(unix+10*totpPeriod, 0)); ok {
+			t.Errorf("Expected %s to be expired at %d [FAILED]", code, unix+10*totpPeriod)
+		}
+	}
+	if _, ok := checkTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "287082", time.Unix(59, 0)); !ok {
+		t.Errorf("Expected lower case secret with spaces to be accepted [FAILED]")
+	}
+}
+
+// writeTOTPUser stores a user config with a key, a password and a TOTP
+// secret, and returns the signer of the key.
+func writeTOTPUser(t *testing.T, user string, secret string) ssh.Signer {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.SSHAuth = t.TempDir()
+	config := fmt.Sprintf(
+		"password: %q\nauthorized_keys:\n  - %q\nallowed_bindings:\n  - \"example.com\"\ntotp_secret: %q\n",
+		"secret", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), secret,
+	)
+	err = os.WriteFile(path.Join(opts.SSHAuth, user+".yaml"), []byte(config), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func currentTOTPCode(t *testing.T, secret string) string {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, uint64(time.Now().Unix()/totpPeriod))
+}
+
+func TestPublicKeyQueryDoesNotUnlockSecondFactor(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	signer := writeTOTPUser(t, "alice", secret)
+	conn := &testSSHConn{user: "alice", remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}}
+	// a key query runs the public key callback without any signature
+	_, err := handleSSHPublicKeyAuth(conn, signer.PublicKey())
+	var partialSuccess *ssh.PartialSuccessError
+	if !errors.As(err, &partialSuccess) {
+		t.Fatalf("Expected the key to be a partial success, got %v [FAILED]", err)
+	}
+	var questions []string
+	_, err = handleKeyboardInteractiveAuth(conn, func(user, instruction string, asked []string, echos []bool) ([]string, error) {
+		questions = asked
+		answers := make([]string, len(asked))
+		for i := range answers {
+			answers[i] = currentTOTPCode(t, secret)
+		}
+		return answers, nil
+	})
+	if err == nil || len(questions) != 2 {
+		t.Errorf("Expected keyboard-interactive to still ask for the password after a key query, asked %q (%v) [FAILED]", questions, err)
+	}
+}
+
+func TestPublicKeyWithSecondFactor(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	signer := writeTOTPUser(t, "bob", secret)
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback:           handleSSHPublicKeyAuth,
+		PasswordCallback:            handlePasswordAuth,
+		KeyboardInteractiveCallback: handleKeyboardInteractiveAuth,
+	}
+	serverConfig.AddHostKey(hostKey)
+	client, server := tcpPair(t)
+	results := make(chan *ssh.Permissions, 1)
+	go func() {
+		conn, channels, requests, err := ssh.NewServerConn(server, serverConfig)
+		if err != nil {
+			results <- nil
+			return
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			for newChannel := range channels {
+				_ = newChannel.Reject(ssh.Prohibited, "")
+			}
+		}()
+		results <- conn.Permissions
+	}()
+	var questions []string
+	clientConfig := &ssh.ClientConfig{
+		User: "bob",
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+			ssh.KeyboardInteractive(func(user, instruction string, asked []string, echos []bool) ([]string, error) {
+				questions = asked
+				return []string{currentTOTPCode(t, secret)}, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	conn, _, _, err := ssh.NewClientConn(client, client.RemoteAddr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("Expected the key and the code to log in, got %v [FAILED]", err)
+	}
+	defer conn.Close()
+	permissions := <-results
+	if permissions == nil || permissions.Extensions[extensionAuthMethod] != "publickey+keyboard-interactive" {
+		t.Errorf("Expected a publickey+keyboard-interactive login, got %v [FAILED]", permissions)
+	}
+	if len(questions) != 1 {
+		t.Errorf("Expected only the verification code to be asked after the key, asked %q [FAILED]", questions)
+	}
+}
+
+func TestCertificateWithSecondFactor(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	signer := writeTOTPUser(t, "carol", secret)
+	_, caPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedUserCAs = NewSet[string]()
+	trustedUserCAs.Add(string(ca.PublicKey().Marshal()))
+	defer func() {
+		trustedUserCAs = NewSet[string]()
+	}()
+	conn := &testSSHConn{user: "carol", remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2222}}
+	for _, extensions := range []map[string]string{nil, {certBindingsExtension: "*.example.com"}} {
+		cert := &ssh.Certificate{
+			Key:             signer.PublicKey(),
+			CertType:        ssh.UserCert,
+			ValidPrincipals: []string{"carol"},
+			ValidBefore:     ssh.CertTimeInfinity,
+			Permissions:     ssh.Permissions{Extensions: extensions},
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		_, err = handleSSHPublicKeyAuth(conn, cert)
+		var partialSuccess *ssh.PartialSuccessError
+		if !errors.As(err, &partialSuccess) {
+			t.Errorf("Expected a certificate of a user with a TOTP secret to need the code, got %v [FAILED]", err)
+			continue
+		}
+		lastTOTPCounter.Remove("carol")
+		var questions []string
+		permissions, err := partialSuccess.Next.KeyboardInteractiveCallback(conn, func(user, instruction string, asked []string, echos []bool) ([]string, error) {
+			questions = asked
+			return []string{currentTOTPCode(t, secret)}, nil
+		})
+		if err != nil || len(questions) != 1 {
+			t.Errorf("Expected only the code to be asked after the certificate, asked %q (%v) [FAILED]", questions, err)
+		} else if permissions.Extensions[extensionAuthMethod] != "certificate+keyboard-interactive" {
+			t.Errorf("Expected a certificate+keyboard-interactive login, got %v [FAILED]", permissions.Extensions)
+		} else if _, ok := permissions.Extensions[extensions[certBindingsExtension]]; extensions != nil && !ok {
+			t.Errorf("Expected the bindings of the certificate to be granted, got %v [FAILED]", permissions.Extensions)
+		}
+	}
+}
+
+func TestRepeatedPasswordLoginsWithoutSecondFactor(t *testing.T) {
+	writeTOTPUser(t, "dave", "")
+	opts.AuthMaxIP = 1
+	opts.AuthMaxUser = 1
+	opts.AuthWindow = 10
+	opts.AuthBanDuration = 30
+	authGuards = NewAuthGuard()
+	sshBanList = NewBanList()
+	defer func() {
+		authGuards = NewAuthGuard()
+		sshBanList = NewBanList()
+	}()
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback:           handleSSHPublicKeyAuth,
+		PasswordCallback:            handlePasswordAuth,
+		KeyboardInteractiveCallback: handleKeyboardInteractiveAuth,
+		AuthLogCallback:             handleAuthLog,
+	}
+	serverConfig.AddHostKey(hostKey)
+	for i := 0; i < 3; i++ {
+		client, server := tcpPair(t)
+		go func() {
+			conn, _, _, err := ssh.NewServerConn(server, serverConfig)
+			if err == nil {
+				_ = conn.Close()
+			}
+		}()
+		// like OpenSSH, try keyboard-interactive before the password
+		conn, _, _, err := ssh.NewClientConn(client, client.RemoteAddr().String(), &ssh.ClientConfig{
+			User: "dave",
+			Auth: []ssh.AuthMethod{
+				ssh.KeyboardInteractive(func(user, instruction string, asked []string, echos []bool) ([]string, error) {
+					t.Errorf("Expected no questions for a user without a TOTP secret, asked %q [FAILED]", asked)
+					return make([]string, len(asked)), nil
+				}),
+				ssh.Password("secret"),
+			},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			t.Fatalf("Expected login %d with the password to work, got %v [FAILED]", i+1, err)
+		}
+		_ = conn.Close()
+	}
+	if authGuards.UserLocked("dave") {
+		t.Errorf("Expected successful password logins not to lock the user [FAILED]")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TrafficStats is a point-in-time copy of a Traffic counter. BytesIn is the
+// traffic sent by players towards the backend, BytesOut the opposite way.
+type TrafficStats struct {
+	Connections uint64 `json:"connections"`
+	BytesIn     uint64 `json:"bytes_in"`
+	BytesOut    uint64 `json:"bytes_out"`
+}
+
+type Traffic struct {
+	connections atomic.Uint64
+	bytesIn     atomic.Uint64
+	bytesOut    atomic.Uint64
+}
+
+type usageStore struct {
+	months map[string]map[string]*Traffic
+	lock   sync.Mutex
+}
+
+type UsageStore interface {
+	User(user string) *Traffic
+	Stats(month string, user string) TrafficStats
+	Snapshot() map[string]map[string]TrafficStats
+	Restore(snapshot map[string]map[string]TrafficStats)
+}
+
+var usage = NewUsageStore()
+
+func (t *Traffic) AddConnection() {
+	t.connections.Add(1)
+}
+
+func (t *Traffic) AddIn(n int) {
+	t.bytesIn.Add(uint64(n))
+}
+
+func (t *Traffic) AddOut(n int) {
+	t.bytesOut.Add(uint64(n))
+}
+
+func (t *Traffic) Stats() TrafficStats {
+	return TrafficStats{
+		Connections: t.connections.Load(),
+		BytesIn:     t.bytesIn.Load(),
+		BytesOut:    t.bytesOut.Load(),
+	}
+}
+
+func NewUsageStore() UsageStore {
+	return &usageStore{
+		months: make(map[string]map[string]*Traffic),
+	}
+}
+
+func currentMonth() string {
+	return time.Now().Format("2006-01")
+}
+
+// User returns the counter of the given user for the current month.
+func (u *usageStore) User(user string) *Traffic {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	month := currentMonth()
+	users, ok := u.months[month]
+	if !ok {
+		users = make(map[string]*Traffic)
+		u.months[month] = users
+	}
+	traffic, ok := users[user]
+	if !ok {
+		traffic = &Traffic{}
+		users[user] = traffic
+	}
+	return traffic
+}
+
+func (u *usageStore) Stats(month string, user string) TrafficStats {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if traffic, ok := u.months[month][user]; ok {
+		return traffic.Stats()
+	}
+	return TrafficStats{}
+}
+
+func (u *usageStore) Snapshot() map[string]map[string]TrafficStats {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	snapshot := make(map[string]map[string]TrafficStats)
+	for month, users := range u.months {
+		snapshot[month] = make(map[string]TrafficStats)
+		for user, traffic := range users {
+			snapshot[month][user] = traffic.Stats()
+		}
+	}
+	return snapshot
+}
+
+func (u *usageStore) Restore(snapshot map[string]map[string]TrafficStats) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	for month, users := range snapshot {
+		if _, ok := u.months[month]; !ok {
+			u.months[month] = make(map[string]*Traffic)
+		}
+		for user, stats := range users {
+			traffic, ok := u.months[month][user]
+			if !ok {
+				traffic = &Traffic{}
+				u.months[month][user] = traffic
+			}
+			traffic.connections.Add(stats.Connections)
+			traffic.bytesIn.Add(stats.BytesIn)
+			traffic.bytesOut.Add(stats.BytesOut)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path"
+	"testing"
+)
+
+func TestUsageStore(t *testing.T) {
+	store := NewUsageStore()
+	traffic := store.User("alice")
+	traffic.AddConnection()
+	traffic.AddIn(100)
+	traffic.AddOut(250)
+	if store.User("alice") != traffic {
+		t.Errorf("Expected the same counter for the same user and month [FAILED]")
+	}
+	expected := TrafficStats{Connections: 1, BytesIn: 100, BytesOut: 250}
+	if stats := store.Stats(currentMonth(), "alice"); stats != expected {
+		t.Errorf("Expected %+v, got %+v [FAILED]", expected, stats)
+	}
+	if stats := store.Stats(currentMonth(), "bob"); stats != (TrafficStats{}) {
+		t.Errorf("Expected no traffic for an unknown user, got %+v [FAILED]", stats)
+	}
+	restored := NewUsageStore()
+	restored.User("alice").AddIn(1)
+	restored.Restore(store.Snapshot())
+	expected.BytesIn++
+	if stats := restored.Stats(currentMonth(), "alice"); stats != expected {
+		t.Errorf("Expected restored traffic to add up to %+v, got %+v [FAILED]", expected, stats)
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	file := path.Join(t.TempDir(), "state.json")
+	usage = NewUsageStore()
+	if err := loadState(file); err != nil {
+		t.Errorf("Expected a missing state file to be ignored, got %v [FAILED]", err)
+	}
+	usage.User("alice").AddOut(42)
+	if err := saveState(file); err != nil {
+		t.Fatal(err)
+	}
+	usage = NewUsageStore()
+	if err := loadState(file); err != nil {
+		t.Fatal(err)
+	}
+	if stats := usage.Stats(currentMonth(), "alice"); stats.BytesOut != 42 {
+		t.Errorf("Expected the saved traffic to be loaded, got %+v [FAILED]", stats)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 40:         "3.0 TiB",
+	}
+	for n, expected := range cases {
+		if formatted := formatBytes(n); formatted != expected {
+			t.Errorf("Expected %d to be formatted as %s, got %s [FAILED]", n, expected, formatted)
+		}
+	}
+}
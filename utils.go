@@ -1,7 +1,23 @@
 package main
 
-import "io"
+import (
+	"fmt"
+	"io"
+)
 
 func Close(c io.Closer) {
 	_ = c.Close()
 }
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}